@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+
+	tarbuild "github.com/fd/tar-utils/pkg/build"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type applyCommand struct {
+	baseArchive  string
+	deltaArchive string
+	outputTar    string
+}
+
+func (c *applyCommand) register(app *kingpin.Application) *kingpin.CmdClause {
+	cmd := app.Command("apply", "Overlay a delta archive onto a base archive")
+	cmd.Arg("base", "The base archive").Required().StringVar(&c.baseArchive)
+	cmd.Arg("delta", "The delta archive, as written by delta").Required().StringVar(&c.deltaArchive)
+	cmd.Flag("output", "Path to output Tar archive").Short('o').Default("-").PlaceHolder("FILE").StringVar(&c.outputTar)
+	return cmd
+}
+
+func (c *applyCommand) run() error {
+	base, err := loadArchive(c.baseArchive)
+	if err != nil {
+		return err
+	}
+	delta, err := loadArchive(c.deltaArchive)
+	if err != nil {
+		return err
+	}
+
+	err = tarbuild.ApplyDelta(base, delta)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	err = tarbuild.WriteTar(&buf, base)
+	if err != nil {
+		return err
+	}
+
+	return putStream(c.outputTar, &buf)
+}
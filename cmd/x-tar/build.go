@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	tarbuild "github.com/fd/tar-utils/pkg/build"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type buildCommand struct {
+	contextDir  string
+	tarfileName string
+	outputTar   string
+	transforms  []string
+	mtime       string
+	format      string
+	xattrs      bool
+	symlinks    bool
+	zipMethod   string
+	verify      bool
+	manifest    string
+	mtree       string
+	progress    bool
+
+	deb         bool
+	controlFile string
+
+	layersDir   string
+	ociLayout   string
+	dockerImage string
+	baseImage   string
+	platform    string
+	entrypoint  string
+	env         []string
+	workdir     string
+	user        string
+	labels      map[string]string
+}
+
+func (c *buildCommand) register(app *kingpin.Application) *kingpin.CmdClause {
+	cmd := app.Command("build", "Make a new tar file")
+	cmd.Arg("context-dir", "The context directory for the build").Default(".").ExistingDirVar(&c.contextDir)
+	cmd.Flag("tarfile", "Tarfile location").Short('t').PlaceHolder("FILE").StringVar(&c.tarfileName)
+	cmd.Flag("output", "Path to output Tar archive").Short('o').Default("-").PlaceHolder("FILE").StringVar(&c.outputTar)
+	cmd.Flag("transform", "Rename paths with a sed expression (s/regex/replacement/flags)").PlaceHolder("EXPR").StringsVar(&c.transforms)
+	cmd.Flag("mtime", "Modification time of the entries: fixed, source-date-epoch, preserve or a time").Default("fixed").PlaceHolder("MODE").StringVar(&c.mtime)
+	cmd.Flag("format", "Format of the archive: ustar, pax, gnu, cpio or zip").PlaceHolder("FORMAT").StringVar(&c.format)
+	cmd.Flag("xattrs", "Capture extended attributes from the context").BoolVar(&c.xattrs)
+	cmd.Flag("symlinks", "Keep the symbolic links of the context").BoolVar(&c.symlinks)
+	cmd.Flag("manifest", "Write a JSON manifest of the archive with SHA-256 digests to FILE").PlaceHolder("FILE").StringVar(&c.manifest)
+	cmd.Flag("mtree", "Write an mtree specification of the archive to FILE").PlaceHolder("FILE").StringVar(&c.mtree)
+	cmd.Flag("verify-reproducible", "Build twice with different scan orders and time zones and compare the archives").BoolVar(&c.verify)
+	cmd.Flag("progress", "Show the progress of the build on stderr").BoolVar(&c.progress)
+	cmd.Flag("zip-method", "Compression of zip archives: deflate or store").PlaceHolder("METHOD").StringVar(&c.zipMethod)
+
+	cmd.Flag("deb", "Write a Debian package instead of a tar archive").BoolVar(&c.deb)
+	cmd.Flag("control", "Debian control file, instead of the one set with CONTROL").PlaceHolder("FILE").ExistingFileVar(&c.controlFile)
+
+	cmd.Flag("layers", "Write the layers of the Tarfile as separate archives to DIR").PlaceHolder("DIR").StringVar(&c.layersDir)
+	cmd.Flag("oci", "Write an OCI image layout to DIR instead of a tar archive").PlaceHolder("DIR").StringVar(&c.ociLayout)
+	cmd.Flag("docker-image", "Write an image tarball for docker load, named NAME:TAG").PlaceHolder("NAME:TAG").StringVar(&c.dockerImage)
+	cmd.Flag("base", "Image tarball (docker save) to add the layers to").PlaceHolder("FILE").ExistingFileVar(&c.baseImage)
+	cmd.Flag("platform", "Platform of the image").Default("linux/amd64").PlaceHolder("OS/ARCH").StringVar(&c.platform)
+	cmd.Flag("entrypoint", "Entrypoint of the image, a command or a JSON array").PlaceHolder("CMD").StringVar(&c.entrypoint)
+	cmd.Flag("env", "Environment variable of the image").PlaceHolder("KEY=VALUE").StringsVar(&c.env)
+	cmd.Flag("workdir", "Working directory of the image").PlaceHolder("DIR").StringVar(&c.workdir)
+	cmd.Flag("user", "User of the image").PlaceHolder("USER").StringVar(&c.user)
+	cmd.Flag("label", "Label of the image").PlaceHolder("KEY=VALUE").StringMapVar(&c.labels)
+	return cmd
+}
+
+func (c *buildCommand) run() error {
+	if c.tarfileName == "" {
+		c.tarfileName = path.Join(c.contextDir, "Tarfile")
+	}
+
+	if c.format == "" {
+		switch {
+		case strings.HasSuffix(c.outputTar, ".cpio"), strings.HasSuffix(c.outputTar, ".cpio.gz"):
+			c.format = string(tarbuild.OutputCPIO)
+		case strings.HasSuffix(c.outputTar, ".zip"):
+			c.format = string(tarbuild.OutputZIP)
+		}
+	}
+
+	opts, err := c.options()
+	if err != nil {
+		return err
+	}
+
+	if (c.manifest != "" || c.mtree != "") && (c.deb || c.layersDir != "" || c.ociLayout != "" || c.dockerImage != "") {
+		return fmt.Errorf("--manifest and --mtree are only supported for archives")
+	}
+
+	var manifest, mtree bytes.Buffer
+	if c.manifest != "" {
+		opts = append(opts, tarbuild.WithManifest(&manifest))
+	}
+	if c.mtree != "" {
+		opts = append(opts, tarbuild.WithMtree(&mtree))
+	}
+
+	if c.progress {
+		bar := &progressBar{w: os.Stderr}
+		opts = append(opts, tarbuild.WithProgress(bar.event))
+		defer bar.done()
+	}
+
+	if c.verify && (c.manifest != "" || c.mtree != "") {
+		return fmt.Errorf("--manifest and --mtree can't be written with --verify-reproducible")
+	}
+	if c.verify && (c.deb || c.layersDir != "" || c.ociLayout != "" || c.dockerImage != "") {
+		return fmt.Errorf("--verify-reproducible is only supported for archives")
+	}
+
+	// An interrupt stops the build between two steps.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	if c.verify {
+		err := tarbuild.VerifyReproducibleContext(ctx, c.contextDir, c.tarfileName, opts...)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stderr, "the build is reproducible")
+		return nil
+	}
+
+	if c.baseImage != "" && c.ociLayout == "" && c.dockerImage == "" {
+		return fmt.Errorf("--base is only supported with --oci and --docker-image")
+	}
+
+	img, err := c.imageConfig()
+	if err != nil {
+		return err
+	}
+
+	if c.layersDir != "" {
+		return c.writeLayers(ctx, opts)
+	}
+
+	if c.ociLayout != "" {
+		return tarbuild.BuildOCILayoutContext(ctx, c.ociLayout, c.contextDir, c.tarfileName, img, opts...)
+	}
+
+	var buf bytes.Buffer
+
+	switch {
+	case c.deb:
+		err = tarbuild.BuildDebContext(ctx, &buf, c.contextDir, c.tarfileName, opts...)
+	case c.dockerImage != "":
+		err = tarbuild.BuildDockerImageContext(ctx, &buf, c.dockerImage, c.contextDir, c.tarfileName, img, opts...)
+	default:
+		err = tarbuild.BuildContext(ctx, &buf, c.contextDir, c.tarfileName, opts...)
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.manifest != "" {
+		err := ioutil.WriteFile(c.manifest, manifest.Bytes(), 0644)
+		if err != nil {
+			return err
+		}
+	}
+	if c.mtree != "" {
+		err := ioutil.WriteFile(c.mtree, mtree.Bytes(), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return putStream(c.outputTar, &buf)
+}
+
+// writeLayers writes each layer to <dir>/<n>[-<name>].tar.
+func (c *buildCommand) writeLayers(ctx context.Context, opts []tarbuild.Option) error {
+	layers, err := tarbuild.BuildLayersContext(ctx, c.contextDir, c.tarfileName, opts...)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(c.layersDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	for i, l := range layers {
+		name := fmt.Sprintf("%02d", i+1)
+		if l.Name != "" {
+			name += "-" + l.Name
+		}
+
+		err := ioutil.WriteFile(filepath.Join(c.layersDir, name+".tar"), l.Data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *buildCommand) options() ([]tarbuild.Option, error) {
+	mtimeOpt, err := tarbuild.ParseModTime(c.mtime)
+	if err != nil {
+		return nil, err
+	}
+
+	// --format selects the header format of tar archives, or another kind
+	// of archive.
+	output, format := tarbuild.OutputTar, tarbuild.FormatDefault
+	switch o := tarbuild.Output(c.format); o {
+	case tarbuild.OutputCPIO, tarbuild.OutputZIP:
+		output = o
+	default:
+		format, err = tarbuild.ParseFormat(c.format)
+		if err != nil {
+			return nil, fmt.Errorf("invalid format %q: expected ustar, pax, gnu, cpio or zip", c.format)
+		}
+	}
+
+	zipMethod, err := tarbuild.ParseZipMethod(c.zipMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []tarbuild.Option{mtimeOpt, tarbuild.WithFormat(format), tarbuild.WithOutput(output), tarbuild.WithZipMethod(zipMethod)}
+	if strings.HasSuffix(c.outputTar, ".cpio.gz") {
+		opts = append(opts, tarbuild.WithCompression(tarbuild.CompressionGzip))
+	}
+	if c.symlinks {
+		opts = append(opts, tarbuild.WithContextSymlinks())
+	}
+	if c.xattrs && runtime.GOOS != "linux" {
+		fmt.Fprintf(os.Stderr, "warning: --xattrs is not supported on %s, extended attributes are not captured\n", runtime.GOOS)
+	} else if c.xattrs {
+		opts = append(opts, tarbuild.WithContextXattrs())
+	}
+	for _, expr := range c.transforms {
+		opts = append(opts, tarbuild.WithTransform(expr))
+	}
+	if c.controlFile != "" {
+		opts = append(opts, tarbuild.WithControlFile(c.controlFile))
+	}
+
+	return opts, nil
+}
+
+func (c *buildCommand) imageConfig() (tarbuild.ImageConfig, error) {
+	img := tarbuild.ImageConfig{
+		Base:       c.baseImage,
+		Env:        c.env,
+		WorkingDir: c.workdir,
+		User:       c.user,
+		Labels:     c.labels,
+	}
+
+	idx := strings.IndexByte(c.platform, '/')
+	if idx <= 0 || idx == len(c.platform)-1 {
+		return img, fmt.Errorf("invalid platform %q: expected OS/ARCH", c.platform)
+	}
+	img.OS = c.platform[:idx]
+	img.Architecture = c.platform[idx+1:]
+
+	if strings.HasPrefix(c.entrypoint, "[") {
+		err := json.Unmarshal([]byte(c.entrypoint), &img.Entrypoint)
+		if err != nil {
+			return img, fmt.Errorf("invalid entrypoint %q: %v", c.entrypoint, err)
+		}
+	} else {
+		img.Entrypoint = strings.Fields(c.entrypoint)
+	}
+
+	return img, nil
+}
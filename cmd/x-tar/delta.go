@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+
+	tarbuild "github.com/fd/tar-utils/pkg/build"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type deltaCommand struct {
+	oldArchive string
+	newArchive string
+	outputTar  string
+}
+
+func (c *deltaCommand) register(app *kingpin.Application) *kingpin.CmdClause {
+	cmd := app.Command("delta", "Write the changes between two tar archives with whiteouts for removed entries")
+	cmd.Arg("old", "The old archive, or a directory with a Tarfile").Required().StringVar(&c.oldArchive)
+	cmd.Arg("new", "The new archive, or a directory with a Tarfile").Required().StringVar(&c.newArchive)
+	cmd.Flag("output", "Path to output Tar archive").Short('o').Default("-").PlaceHolder("FILE").StringVar(&c.outputTar)
+	return cmd
+}
+
+func (c *deltaCommand) run() error {
+	prev, err := loadArchive(c.oldArchive)
+	if err != nil {
+		return err
+	}
+	cur, err := loadArchive(c.newArchive)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	err = tarbuild.WriteTar(&buf, tarbuild.Delta(prev, cur))
+	if err != nil {
+		return err
+	}
+
+	return putStream(c.outputTar, &buf)
+}
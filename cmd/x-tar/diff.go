@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	tarbuild "github.com/fd/tar-utils/pkg/build"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type diffCommand struct {
+	archiveA string
+	archiveB string
+	json     bool
+}
+
+func (c *diffCommand) register(app *kingpin.Application) *kingpin.CmdClause {
+	cmd := app.Command("diff", "Show the changes between two tar archives")
+	cmd.Arg("a", "The old archive, or a directory with a Tarfile").Required().StringVar(&c.archiveA)
+	cmd.Arg("b", "The new archive, or a directory with a Tarfile").Required().StringVar(&c.archiveB)
+	cmd.Flag("json", "Write the changes as JSON").BoolVar(&c.json)
+	return cmd
+}
+
+func (c *diffCommand) run() error {
+	a, err := loadArchive(c.archiveA)
+	if err != nil {
+		return err
+	}
+	b, err := loadArchive(c.archiveB)
+	if err != nil {
+		return err
+	}
+
+	changes, err := tarbuild.DiffDirs(a, b)
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		if changes == nil {
+			changes = []tarbuild.Change{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(changes)
+	}
+
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+
+	// Show the text files whose contents changed.
+	for _, change := range changes {
+		if change.Kind != tarbuild.ContentChanged {
+			continue
+		}
+
+		dataA, errA := a.ReadFile(change.Path)
+		dataB, errB := b.ReadFile(change.Path)
+		if errA != nil || errB != nil || !isText(dataA) || !isText(dataB) {
+			continue
+		}
+
+		fmt.Print("\n" + unifiedDiff("a/"+change.Path, "b/"+change.Path, dataA, dataB))
+	}
+
+	return nil
+}
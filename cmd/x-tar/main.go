@@ -21,37 +21,39 @@ func main() {
 }
 
 func run() error {
-	var (
-		contextDir  string
-		tarfileName string
-		outputTar   string
-	)
-
 	app := kingpin.New("x-tar", "Tar utilities").Version("1.0").Author("Simon Menke")
 
-	buildCmd := app.Command("build", "Make a new tar file")
-	buildCmd.Arg("context-dir", "The context directory for the build").Default(".").ExistingDirVar(&contextDir)
-	buildCmd.Flag("tarfile", "Tarfile location").Short('t').PlaceHolder("FILE").StringVar(&tarfileName)
-	buildCmd.Flag("output", "Path to output Tar archive").Short('o').Default("-").PlaceHolder("FILE").StringVar(&outputTar)
+	build := &buildCommand{labels: map[string]string{}}
+	buildCmd := build.register(app)
+
+	verify := &verifyCommand{}
+	verifyCmd := verify.register(app)
+
+	diff := &diffCommand{}
+	diffCmd := diff.register(app)
+
+	delta := &deltaCommand{}
+	deltaCmd := delta.register(app)
+
+	apply := &applyCommand{}
+	applyCmd := apply.register(app)
 
 	switch kingpin.MustParse(app.Parse(os.Args[1:])) {
 
 	case buildCmd.FullCommand():
-		if tarfileName == "" {
-			tarfileName = path.Join(contextDir, "Tarfile")
-		}
+		return build.run()
 
-		var buf bytes.Buffer
+	case verifyCmd.FullCommand():
+		return verify.run()
 
-		err := tarbuild.Build(&buf, contextDir, tarfileName)
-		if err != nil {
-			return err
-		}
+	case diffCmd.FullCommand():
+		return diff.run()
 
-		err = putStream(outputTar, &buf)
-		if err != nil {
-			return err
-		}
+	case deltaCmd.FullCommand():
+		return delta.run()
+
+	case applyCmd.FullCommand():
+		return apply.run()
 	}
 
 	return nil
@@ -73,3 +75,29 @@ func putStream(name string, buf *bytes.Buffer) error {
 	}
 	return ioutil.WriteFile(name, buf.Bytes(), 0644)
 }
+
+// loadArchive loads a tar archive, or builds the Tarfile of a directory.
+func loadArchive(name string) (*tarbuild.Dir, error) {
+	if fi, err := os.Stat(name); err == nil && fi.IsDir() {
+		var buf bytes.Buffer
+		err := tarbuild.Build(&buf, name, path.Join(name, "Tarfile"))
+		if err != nil {
+			return nil, err
+		}
+		return tarbuild.NewDirFromTar(&buf)
+	}
+
+	r, err := openStream(name)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := r.(*os.File); ok && f != os.Stdin {
+		defer f.Close()
+	}
+
+	d, err := tarbuild.NewDirFromTar(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return d, nil
+}
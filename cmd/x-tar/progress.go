@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	tarbuild "github.com/fd/tar-utils/pkg/build"
+)
+
+const (
+	progressWidth    = 30
+	progressInterval = 100 * time.Millisecond
+)
+
+// progressBar renders the events of a build on a single line of a terminal.
+type progressBar struct {
+	w     io.Writer
+	last  time.Time
+	shown bool
+}
+
+func (p *progressBar) event(e tarbuild.Event) {
+	switch e.Kind {
+	case tarbuild.EventOpStarted:
+		p.show(fmt.Sprintf("[%d/%d] %s", e.N, e.Total, strings.Join(append([]string{e.Op.Name}, e.Op.Args...), " ")))
+
+	case tarbuild.EventEntry:
+		// Entries are written quickly, only the last one is always shown.
+		if e.N < e.Total && time.Since(p.last) < progressInterval {
+			return
+		}
+
+		done := 1.0
+		switch {
+		case e.TotalBytes > 0:
+			done = float64(e.Bytes) / float64(e.TotalBytes)
+		case e.Total > 0:
+			done = float64(e.N) / float64(e.Total)
+		}
+		n := int(done * progressWidth)
+
+		p.show(fmt.Sprintf("[%s%s] %3.0f%% %s/%s %s",
+			strings.Repeat("=", n), strings.Repeat(" ", progressWidth-n),
+			done*100, formatSize(e.Bytes), formatSize(e.TotalBytes), e.Path))
+	}
+}
+
+func (p *progressBar) show(line string) {
+	fmt.Fprintf(p.w, "\r\033[K%s", line)
+	p.last = time.Now()
+	p.shown = true
+}
+
+// done ends the line of the progress bar.
+func (p *progressBar) done() {
+	if p.shown {
+		fmt.Fprintln(p.w)
+	}
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
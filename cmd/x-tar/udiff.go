@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// maxDiffSize and maxDiffLines limit the files shown as a text diff.
+	maxDiffSize  = 64 << 10
+	maxDiffLines = 2000
+
+	diffContext = 3
+)
+
+// isText reports whether data looks like a small text file.
+func isText(data []byte) bool {
+	return len(data) <= maxDiffSize && bytes.Count(data, []byte{'\n'}) <= maxDiffLines &&
+		utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
+
+type diffOp struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// unifiedDiff returns a unified diff of a and b, or "" when they are equal.
+func unifiedDiff(nameA, nameB string, a, b []byte) string {
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	var (
+		buf     strings.Builder
+		changed bool
+	)
+
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// A hunk starts diffContext lines before the first change and ends
+		// when there are more than 2*diffContext unchanged lines.
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			} else if j-end >= 2*diffContext {
+				break
+			}
+		}
+		end += diffContext
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		lineA, lineB := 1, 1
+		for _, op := range ops[:start] {
+			if op.kind != '+' {
+				lineA++
+			}
+			if op.kind != '-' {
+				lineB++
+			}
+		}
+		countA, countB := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				countA++
+			}
+			if op.kind != '-' {
+				countB++
+			}
+		}
+
+		if !changed {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", nameA, nameB)
+			changed = true
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(lineA, countA), hunkRange(lineB, countB))
+		for _, op := range ops[start:end] {
+			buf.WriteByte(op.kind)
+			buf.WriteString(op.line)
+			buf.WriteByte('\n')
+		}
+
+		i = end
+	}
+
+	return buf.String()
+}
+
+func hunkRange(line, count int) string {
+	if count == 0 {
+		line--
+	}
+	if count == 1 {
+		return fmt.Sprint(line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
+
+func splitLines(data []byte) []string {
+	s := strings.TrimSuffix(string(data), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// diffLines computes the edit script from a to b with the longest common
+// subsequence of their lines.
+func diffLines(a, b []string) []diffOp {
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, diffOp{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			ops = append(ops, diffOp{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, diffOp{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, diffOp{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, diffOp{'+', b[j]})
+	}
+
+	return ops
+}
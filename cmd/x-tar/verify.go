@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	tarbuild "github.com/fd/tar-utils/pkg/build"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+type verifyCommand struct {
+	mtree   string
+	archive string
+}
+
+func (c *verifyCommand) register(app *kingpin.Application) *kingpin.CmdClause {
+	cmd := app.Command("verify", "Check a tar archive against a specification")
+	cmd.Flag("mtree", "mtree specification of the archive").Required().PlaceHolder("SPEC").ExistingFileVar(&c.mtree)
+	cmd.Arg("archive", "The tar archive to check, may be gzip compressed").Default(stdio).StringVar(&c.archive)
+	return cmd
+}
+
+func (c *verifyCommand) run() error {
+	spec, err := os.Open(c.mtree)
+	if err != nil {
+		return err
+	}
+	defer spec.Close()
+
+	archive, err := openStream(c.archive)
+	if err != nil {
+		return err
+	}
+
+	diffs, err := tarbuild.VerifyMtree(spec, archive)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range diffs {
+		fmt.Println(d)
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("%s doesn't match %s: %d differences", c.archive, c.mtree, len(diffs))
+	}
+	return nil
+}
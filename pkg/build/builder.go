@@ -0,0 +1,115 @@
+package tarbuild
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Builder makes a Spec with method calls instead of a Tarfile and builds it:
+//
+//	err := tarbuild.NewBuilder("context", tarbuild.WithCompression(tarbuild.CompressionGzip)).
+//		Copy("bin/app", "usr/bin/app").
+//		Chmod("0755", "usr/bin/app").
+//		Write(w)
+//
+// The methods take the arguments of the Tarfile commands of the same name;
+// Op adds any command, with flags like -R. Invalid commands are reported by
+// Write.
+type Builder struct {
+	wd   string
+	spec Spec
+	opts []Option
+}
+
+// NewBuilder returns a Builder for the build context in wd.
+func NewBuilder(wd string, opts ...Option) *Builder {
+	return &Builder{wd: wd, opts: opts}
+}
+
+// Op adds a command.
+func (b *Builder) Op(name string, args ...string) *Builder {
+	b.spec.Commands = append(b.spec.Commands, Op{Name: name, Args: args})
+	return b
+}
+
+// Copy adds a COPY command: the sources followed by the destination.
+func (b *Builder) Copy(args ...string) *Builder {
+	return b.Op("COPY", args...)
+}
+
+// Mkdir adds a MKDIR command.
+func (b *Builder) Mkdir(paths ...string) *Builder {
+	return b.Op("MKDIR", paths...)
+}
+
+// Chmod adds a CHMOD command.
+func (b *Builder) Chmod(mode string, globs ...string) *Builder {
+	return b.Op("CHMOD", append([]string{mode}, globs...)...)
+}
+
+// Chown adds a CHOWN command. owner is <user>, <user>:<group> or :<group>.
+func (b *Builder) Chown(owner string, globs ...string) *Builder {
+	return b.Op("CHOWN", append([]string{owner}, globs...)...)
+}
+
+// Touch adds a TOUCH command which sets the modification time to t.
+func (b *Builder) Touch(t time.Time, globs ...string) *Builder {
+	return b.Op("TOUCH", append([]string{"-d", t.Format(time.RFC3339Nano)}, globs...)...)
+}
+
+// Transform adds a TRANSFORM command.
+func (b *Builder) Transform(exprs ...string) *Builder {
+	return b.Op("TRANSFORM", exprs...)
+}
+
+// Xattr adds an XATTR command.
+func (b *Builder) Xattr(name, value string, globs ...string) *Builder {
+	return b.Op("XATTR", append([]string{name, value}, globs...)...)
+}
+
+// Setcap adds a SETCAP command.
+func (b *Builder) Setcap(caps string, globs ...string) *Builder {
+	return b.Op("SETCAP", append([]string{caps}, globs...)...)
+}
+
+// Mknod adds a MKNOD command for a character or block device.
+func (b *Builder) Mknod(path string, typ NodeType, major, minor int64) *Builder {
+	t := "c"
+	if typ == BlockDevice {
+		t = "b"
+	}
+	return b.Op("MKNOD", path, t, strconv.FormatInt(major, 10), strconv.FormatInt(minor, 10))
+}
+
+// Mkfifo adds a MKFIFO command.
+func (b *Builder) Mkfifo(paths ...string) *Builder {
+	return b.Op("MKFIFO", paths...)
+}
+
+// Manifest adds a MANIFEST command.
+func (b *Builder) Manifest(path string) *Builder {
+	return b.Op("MANIFEST", path)
+}
+
+// Order adds an ORDER command.
+func (b *Builder) Order(patterns ...string) *Builder {
+	return b.Op("ORDER", patterns...)
+}
+
+// Spec returns the commands added so far.
+func (b *Builder) Spec() *Spec {
+	return b.spec.clone()
+}
+
+// Write builds the archive and writes it to dst.
+func (b *Builder) Write(dst io.Writer) error {
+	return b.WriteContext(context.Background(), dst)
+}
+
+// WriteContext is like Write but stops with the error of ctx when ctx is
+// done, like BuildContext.
+func (b *Builder) WriteContext(ctx context.Context, dst io.Writer) error {
+	return BuildSpecContext(ctx, dst, b.wd, &b.spec, b.opts...)
+}
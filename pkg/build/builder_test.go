@@ -0,0 +1,100 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func readHeaders(t *testing.T, r io.Reader) []*tar.Header {
+	t.Helper()
+
+	var headers []*tar.Header
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return headers
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers = append(headers, h)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	mtime := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	b := NewBuilder("testdata", WithCompression(CompressionGzip), WithFormat(FormatPAX)).
+		Copy("a-dir/a.txt", "bin/app").
+		Chmod("0755", "bin/app").
+		Chown("app:staff", "bin/app").
+		Touch(mtime, "bin/app").
+		Op("TESTSYMLINK", "app", "bin/link").
+		Mknod("dev/null", CharDevice, 1, 3)
+
+	var buf bytes.Buffer
+	err := b.Write(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, h := range readHeaders(t, zr) {
+		got = append(got, h.Name)
+		if h.Name == "bin/app" {
+			if h.Mode&07777 != 0755 || h.Uname != "app" || h.Gname != "staff" || !h.ModTime.Equal(mtime) {
+				t.Errorf("unexpected header %o %s:%s %v", h.Mode, h.Uname, h.Gname, h.ModTime)
+			}
+		}
+	}
+
+	expected := []string{"bin/", "bin/app", "bin/link", "dev/", "dev/null"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q but got %q", expected, got)
+	}
+
+	// Building doesn't change the spec of the builder.
+	spec := b.Spec()
+	if len(spec.Commands) != 6 || !reflect.DeepEqual(spec.Commands[0].Args, []string{"a-dir/a.txt", "bin/app"}) {
+		t.Errorf("unexpected spec %v", spec)
+	}
+
+	err = NewBuilder("testdata").Op("NOPE").Write(io.Discard)
+	if err == nil {
+		t.Error("expected an error for an invalid command")
+	}
+}
+
+func TestBuildSpec(t *testing.T) {
+	spec, err := ParseSpec([]byte(`{"Commands": [{"Name": "COPY", "Args": ["*", "/"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = BuildSpec(&buf, "testdata", spec, WithIgnoreFile(".tarignore"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, h := range readHeaders(t, &buf) {
+		got = append(got, h.Name)
+	}
+
+	expected := []string{".tarignore", "a-dir/", "a-dir/a.txt", "a-dir/b.txt"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q but got %q", expected, got)
+	}
+}
@@ -0,0 +1,21 @@
+package tarbuild
+
+// Compression is the compression of the archive written by Build.
+type Compression string
+
+const (
+	// CompressionNone writes the archive as is. It is the default.
+	CompressionNone Compression = ""
+
+	// CompressionGzip compresses the archive with gzip.
+	CompressionGzip Compression = "gzip"
+)
+
+// WithCompression sets the compression of the archive written by Build. Tar
+// and cpio archives can be compressed, zip archives compress their entries
+// instead (see WithZipMethod) and are rejected.
+func WithCompression(c Compression) Option {
+	return func(cfg *buildConfig) {
+		cfg.compression = c
+	}
+}
@@ -0,0 +1,141 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+const cpioTrailer = "TRAILER!!!"
+
+// cpioEntry is an entry of the tree as it is written to a cpio archive.
+type cpioEntry struct {
+	header *tar.Header
+	file   *File
+	ino    int
+	uid    int
+	gid    int
+}
+
+// writeCpio writes the tree as a cpio archive in the newc format, as used
+// for initramfs images. Inodes are numbered in the order of the archive and
+// owners must be numeric (or root) as cpio has no user and group names.
+// Like in tar archives, every file is written as a regular file: the tree has
+// no hard links.
+func (r *result) writeCpio(dst io.Writer) error {
+	var (
+		w       = r.newTarWriter(ioutil.Discard)
+		entries []*cpioEntry
+		invalid []string
+		ino     int
+	)
+
+	err := r.tree.walk("", func(path string, e Entry) error {
+		h, err := e.tarHeader(path, w)
+		if err != nil {
+			return err
+		}
+		h.Name = path
+
+		uid, uidOK := parseOwnerID(h.Uname)
+		gid, gidOK := parseOwnerID(h.Gname)
+		mtime := h.ModTime.Unix()
+		if !uidOK || !gidOK || len(h.PAXRecords) > 0 || h.Size > 0xffffffff || mtime < 0 || mtime > 0xffffffff {
+			invalid = append(invalid, fmt.Sprintf("%q", path))
+			return nil
+		}
+
+		ino++
+		ce := &cpioEntry{header: h, ino: ino, uid: uid, gid: gid}
+		if f, ok := e.(*File); ok {
+			ce.file = f
+		}
+
+		entries = append(entries, ce)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(invalid) > 0 {
+		return fmt.Errorf("the cpio format can't represent %s (owners must be numeric, without xattrs, with times from 1970 to 2106)", strings.Join(invalid, ", "))
+	}
+
+	cw := &cpioWriter{w: dst}
+
+	for _, ce := range entries {
+		h := ce.header
+
+		var data []byte
+		if ce.file != nil {
+			data, err = ce.file.readData()
+			if err != nil {
+				return err
+			}
+		}
+		if h.Typeflag == tar.TypeSymlink {
+			data = []byte(h.Linkname)
+		}
+
+		var size int64
+		if ce.file != nil {
+			size = int64(len(data))
+		}
+		err = r.writeEntry(h.Name, size)
+		if err != nil {
+			return err
+		}
+
+		links := 1
+		if h.Typeflag == tar.TypeDir {
+			links = 2
+		}
+
+		var major, minor int64
+		if h.Typeflag == tar.TypeChar || h.Typeflag == tar.TypeBlock {
+			major, minor = h.Devmajor, h.Devminor
+		}
+
+		cw.writeHeader(h.Name, ce.ino, h.Mode, ce.uid, ce.gid, links, h.ModTime.Unix(), len(data), major, minor)
+		cw.write(data)
+		cw.pad(4)
+	}
+
+	cw.writeHeader(cpioTrailer, 0, 0, 0, 0, 1, 0, 0, 0, 0)
+	cw.pad(512)
+	return cw.err
+}
+
+// cpioWriter writes newc records and keeps the first error.
+type cpioWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (w *cpioWriter) write(data []byte) {
+	if w.err != nil {
+		return
+	}
+
+	n, err := w.w.Write(data)
+	w.n += int64(n)
+	w.err = err
+}
+
+func (w *cpioWriter) pad(align int64) {
+	if rem := w.n % align; rem != 0 {
+		w.write(make([]byte, align-rem))
+	}
+}
+
+func (w *cpioWriter) writeHeader(name string, ino int, mode int64, uid, gid, nlink int, mtime int64, size int, rmajor, rminor int64) {
+	h := fmt.Sprintf("070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+		ino, mode, uid, gid, nlink, mtime, size, 0, 0, rmajor, rminor, len(name)+1, 0)
+
+	w.write([]byte(h + name + "\x00"))
+	w.pad(4)
+}
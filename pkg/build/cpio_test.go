@@ -0,0 +1,113 @@
+package tarbuild
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+func TestBuild_cpio(t *testing.T) {
+	tarfile := `
+COPY a-dir data
+COPY a-dir copy
+COPY a-dir/a.txt data/c.txt
+MKNOD dev/console c 5 1
+CHOWN -R 1000:1000 data
+`
+
+	var buf bytes.Buffer
+	err := Build(&buf, "testdata", writeTarfile(t, tarfile), WithOutput(OutputCPIO))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len()%512 != 0 {
+		t.Errorf("expected the archive to be padded to 512 bytes, got %d", buf.Len())
+	}
+
+	type record struct {
+		ino, mode, uid, nlink, rmajor, rminor int64
+	}
+
+	records := map[string]record{}
+	var names []string
+
+	data := buf.Bytes()
+	for {
+		if string(data[:6]) != "070701" {
+			t.Fatalf("invalid magic %q", data[:6])
+		}
+		field := func(i int) int64 {
+			v, err := strconv.ParseInt(string(data[6+8*i:14+8*i]), 16, 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return v
+		}
+
+		size, nameSize := field(6), field(11)
+		name := string(data[110 : 110+nameSize-1])
+		if name == cpioTrailer {
+			break
+		}
+
+		names = append(names, name)
+		records[name] = record{field(0), field(1), field(2), field(4), field(9), field(10)}
+		data = data[(110+nameSize+3)&^3:]
+		data = data[(size+3)&^3:]
+	}
+
+	expected := []string{"copy", "copy/a.txt", "copy/b.txt", "data", "data/a.txt", "data/b.txt", "data/c.txt", "dev", "dev/console"}
+	if len(names) != len(expected) {
+		t.Fatalf("expected %q but got %q", expected, names)
+	}
+	for i := range names {
+		if names[i] != expected[i] {
+			t.Fatalf("expected %q but got %q", expected, names)
+		}
+	}
+
+	// The copies are separate files, like in tar archives, even when the
+	// tree shares them.
+	inos := map[int64]string{}
+	for _, name := range names {
+		r := records[name]
+		if other, found := inos[r.ino]; found {
+			t.Errorf("%s has the inode of %s", name, other)
+		}
+		inos[r.ino] = name
+		if r.mode&0170000 != 0040000 && r.nlink != 1 {
+			t.Errorf("expected %s to have a single link but got %d", name, r.nlink)
+		}
+	}
+	a := records["data/a.txt"]
+	if records["data/b.txt"].ino == a.ino || records["data/b.txt"].uid != 1000 {
+		t.Errorf("unexpected data/b.txt %+v", records["data/b.txt"])
+	}
+	if c := records["dev/console"]; c.mode != 0020644 || c.rmajor != 5 || c.rminor != 1 {
+		t.Errorf("unexpected dev/console %+v", c)
+	}
+}
+
+func TestBuild_cpioOwners(t *testing.T) {
+	tarfile := `
+COPY a-dir data
+CHOWN jane data/a.txt
+`
+
+	err := Build(&bytes.Buffer{}, "testdata", writeTarfile(t, tarfile), WithOutput(OutputCPIO))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestBuild_cpioTimes(t *testing.T) {
+	tarfile := `
+COPY a-dir data
+TOUCH -d 1969-12-31 data/a.txt
+`
+
+	err := Build(&bytes.Buffer{}, "testdata", writeTarfile(t, tarfile), WithOutput(OutputCPIO))
+	if err == nil {
+		t.Fatal("expected an error for a time before 1970")
+	}
+}
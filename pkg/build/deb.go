@@ -0,0 +1,385 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"time"
+)
+
+func init() {
+	for _, name := range []string{"CONTROL", "PREINST", "POSTINST", "PRERM", "POSTRM"} {
+		registerOp(name, validateDebFile, nil)
+	}
+	registerOp("CONFFILES", requireArgs, nil)
+}
+
+// WithControlFile uses the Debian control file at name for BuildDeb instead
+// of the one set with CONTROL.
+func WithControlFile(name string) Option {
+	return func(c *buildConfig) {
+		c.controlFile = name
+	}
+}
+
+// debInfo holds the package metadata set by the Debian directives of a
+// Tarfile:
+//
+//	CONTROL <src>
+//	CONFFILES <path>...
+//	PREINST <src>
+//	POSTINST <src>
+//	PRERM <src>
+//	POSTRM <src>
+//
+// <src> is a file in the context. CONFFILES takes absolute paths in the
+// package. The directives don't change the tree and are only used by
+// BuildDeb, the other outputs reject them.
+type debInfo struct {
+	control   []byte
+	conffiles []string
+	scripts   map[string][]byte
+}
+
+func validateDebFile(op *Op) error {
+	if len(op.Args) != 1 {
+		return fmt.Errorf("invalid command: %q requires a single file", op.Name)
+	}
+	return nil
+}
+
+func isDebDirective(name string) bool {
+	switch name {
+	case "CONTROL", "CONFFILES", "PREINST", "POSTINST", "PRERM", "POSTRM":
+		return true
+	}
+	return false
+}
+
+// empty reports whether no Debian directive was applied.
+func (d *debInfo) empty() bool {
+	return d.control == nil && len(d.conffiles) == 0 && len(d.scripts) == 0
+}
+
+func (d *debInfo) apply(src *Dir, op Op) error {
+	if op.Name == "CONFFILES" {
+		d.conffiles = append(d.conffiles, op.Args...)
+		return nil
+	}
+
+	data, err := src.ReadFile(op.Args[0])
+	if err != nil {
+		return fmt.Errorf("%s: %s: %v", op.Name, op.Args[0], err)
+	}
+
+	if op.Name == "CONTROL" {
+		d.control = data
+		return nil
+	}
+
+	if d.scripts == nil {
+		d.scripts = map[string][]byte{}
+	}
+	d.scripts[strings.ToLower(op.Name)] = data
+	return nil
+}
+
+// BuildDeb builds the Tarfile and writes it as a Debian binary package.
+func BuildDeb(dst io.Writer, wd, conf string, opts ...Option) error {
+	return BuildDebContext(context.Background(), dst, wd, conf, opts...)
+}
+
+// BuildDebContext is like BuildDeb but stops with the error of ctx when ctx is
+// done, like BuildContext.
+func BuildDebContext(ctx context.Context, dst io.Writer, wd, conf string, opts ...Option) error {
+	var cfg buildConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	// The control file is read before the build, which can take a while.
+	var control []byte
+	if cfg.controlFile != "" {
+		var err error
+		control, err = ioutil.ReadFile(cfg.controlFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	r, err := build(ctx, wd, conf, opts)
+	if err != nil {
+		return err
+	}
+	if control != nil {
+		r.deb.control = control
+	}
+
+	var buf bytes.Buffer
+
+	err = r.writeDeb(&buf)
+	if err != nil {
+		return err
+	}
+
+	_, err = buf.WriteTo(dst)
+	return err
+}
+
+func (r *result) writeDeb(dst io.Writer) error {
+	if r.deb.control == nil {
+		return fmt.Errorf("a Debian package requires a control file (CONTROL or --control)")
+	}
+
+	var data bytes.Buffer
+	err := r.writeDebData(&data)
+	if err != nil {
+		return err
+	}
+
+	md5sums, size, err := r.debChecksums()
+	if err != nil {
+		return err
+	}
+
+	control, err := debControl(r.deb.control, size)
+	if err != nil {
+		return err
+	}
+
+	files := []debControlFile{
+		{"control", control, 0644},
+		{"md5sums", md5sums, 0644},
+	}
+
+	if len(r.deb.conffiles) > 0 {
+		for _, name := range r.deb.conffiles {
+			if !path.IsAbs(name) {
+				return fmt.Errorf("CONFFILES: %s is not an absolute path", name)
+			}
+			_, err := r.tree.GetFile(name)
+			if err != nil {
+				return fmt.Errorf("CONFFILES: %s is not a file in the package", name)
+			}
+		}
+		conffiles := strings.Join(r.deb.conffiles, "\n") + "\n"
+		files = append(files, debControlFile{"conffiles", []byte(conffiles), 0644})
+	}
+
+	for _, name := range []string{"preinst", "postinst", "prerm", "postrm"} {
+		if script, found := r.deb.scripts[name]; found {
+			files = append(files, debControlFile{name, script, 0755})
+		}
+	}
+
+	controlTar, err := r.writeDebControlTar(files)
+	if err != nil {
+		return err
+	}
+
+	controlGz, err := gzipData(controlTar)
+	if err != nil {
+		return err
+	}
+	dataGz, err := gzipData(data.Bytes())
+	if err != nil {
+		return err
+	}
+
+	return writeAr(dst, r.mtime, []arMember{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar.gz", controlGz},
+		{"data.tar.gz", dataGz},
+	})
+}
+
+// writeDebData writes the tree with ./ prefixed paths, like dpkg-deb.
+func (r *result) writeDebData(dst io.Writer) error {
+	err := r.checkTreeOutput()
+	if err != nil {
+		return err
+	}
+
+	w := r.newTarWriter(dst)
+	w.prefix = "./"
+	w.onEntry = r.writeTarEntry
+
+	err = w.checkFormat(r.tree)
+	if err != nil {
+		return err
+	}
+
+	h, err := r.tree.tarHeader("", w)
+	if err != nil {
+		return err
+	}
+	h.Name = ""
+
+	err = w.writeHeader(h)
+	if err != nil {
+		return err
+	}
+
+	err = r.tree.writeEntriesToTar("", w)
+	if err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+// debChecksums returns the md5sums file of the package and its installed
+// size in bytes.
+func (r *result) debChecksums() ([]byte, int64, error) {
+	var (
+		buf  bytes.Buffer
+		size int64
+	)
+
+	err := r.tree.walk("", func(name string, e Entry) error {
+		f, ok := e.(*File)
+		if !ok {
+			return nil
+		}
+
+		data, err := f.readData()
+		if err != nil {
+			return err
+		}
+
+		sum := md5.Sum(data)
+		fmt.Fprintf(&buf, "%s  %s\n", hex.EncodeToString(sum[:]), name)
+		size += int64(len(data))
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return buf.Bytes(), size, nil
+}
+
+// debControl checks the required fields of a control file and adds the
+// Installed-Size field when it is missing.
+func debControl(control []byte, size int64) ([]byte, error) {
+	control = bytes.TrimSpace(control)
+
+	fields := map[string]bool{}
+	for _, line := range strings.Split(string(control), "\n") {
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		if idx := strings.IndexByte(line, ':'); idx > 0 {
+			fields[strings.ToLower(line[:idx])] = true
+		}
+	}
+
+	var missing []string
+	for _, field := range []string{"Package", "Version", "Architecture", "Maintainer", "Description"} {
+		if !fields[strings.ToLower(field)] {
+			missing = append(missing, field)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("the control file is missing %s", strings.Join(missing, ", "))
+	}
+
+	out := append([]byte(nil), control...)
+	if !fields["installed-size"] {
+		out = append(out, fmt.Sprintf("\nInstalled-Size: %d", (size+1023)/1024)...)
+	}
+	return append(out, '\n'), nil
+}
+
+type debControlFile struct {
+	name string
+	data []byte
+	mode int64
+}
+
+func (r *result) writeDebControlTar(files []debControlFile) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w := tar.NewWriter(&buf)
+
+	err := w.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     "./",
+		Mode:     0755,
+		Uname:    "root",
+		Gname:    "root",
+		ModTime:  r.mtime,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range files {
+		err := w.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     "./" + f.name,
+			Mode:     f.mode,
+			Uname:    "root",
+			Gname:    "root",
+			Size:     int64(len(f.data)),
+			ModTime:  r.mtime,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = w.Write(f.data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+type arMember struct {
+	name string
+	data []byte
+}
+
+// writeAr writes members as a common format ar archive, as read by dpkg.
+func writeAr(dst io.Writer, mtime time.Time, members []arMember) error {
+	_, err := io.WriteString(dst, "!<arch>\n")
+	if err != nil {
+		return err
+	}
+
+	for _, m := range members {
+		_, err := fmt.Fprintf(dst, "%-16s%-12d%-6d%-6d%-8o%-10d`\n", m.name, mtime.Unix(), 0, 0, 0100644, len(m.data))
+		if err != nil {
+			return err
+		}
+
+		_, err = dst.Write(m.data)
+		if err != nil {
+			return err
+		}
+
+		// Members are aligned to 2 bytes.
+		if len(m.data)%2 == 1 {
+			_, err = io.WriteString(dst, "\n")
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBuildDeb(t *testing.T) {
+	control := writeTarfile(t, `Package: hello
+Version: 1.0
+Architecture: all
+Maintainer: Jane Doe <jane@example.com>
+Description: says hello
+`)
+
+	tarfile := `
+COPY a-dir etc/hello
+CONFFILES /etc/hello/a.txt
+POSTINST a-dir/b.txt
+`
+
+	var buf bytes.Buffer
+	err := BuildDeb(&buf, "testdata", writeTarfile(t, tarfile), WithControlFile(control))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members := readAr(t, buf.Bytes())
+	if string(members["debian-binary"]) != "2.0\n" {
+		t.Errorf("unexpected debian-binary %q", members["debian-binary"])
+	}
+
+	controlFiles := readTarGz(t, members["control.tar.gz"])
+	if !strings.Contains(string(controlFiles["./control"]), "\nInstalled-Size: 0\n") {
+		t.Errorf("missing Installed-Size in %q", controlFiles["./control"])
+	}
+	if string(controlFiles["./conffiles"]) != "/etc/hello/a.txt\n" {
+		t.Errorf("unexpected conffiles %q", controlFiles["./conffiles"])
+	}
+	if _, found := controlFiles["./postinst"]; !found {
+		t.Errorf("missing postinst")
+	}
+
+	md5sums := strings.Split(strings.TrimSpace(string(controlFiles["./md5sums"])), "\n")
+	if len(md5sums) != 2 || !strings.HasSuffix(md5sums[0], "  etc/hello/a.txt") {
+		t.Errorf("unexpected md5sums %q", md5sums)
+	}
+
+	dataFiles := readTarGz(t, members["data.tar.gz"])
+	for _, name := range []string{"./", "./etc/", "./etc/hello/a.txt"} {
+		if _, found := dataFiles[name]; !found {
+			t.Errorf("missing %q in data.tar.gz", name)
+		}
+	}
+}
+
+func TestBuildDeb_missingFields(t *testing.T) {
+	control := writeTarfile(t, "Package: hello\n")
+
+	err := BuildDeb(ioutil.Discard, "testdata", "testdata/Tarfile", WithControlFile(control))
+	if err == nil || err.Error() != "the control file is missing Version, Architecture, Maintainer, Description" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestBuildDeb_missingControlFile(t *testing.T) {
+	// The Tarfile doesn't exist either, the control file is read first.
+	err := BuildDeb(ioutil.Discard, "testdata", "testdata/missing-Tarfile", WithControlFile("testdata/missing-control"))
+	if !os.IsNotExist(err) || !strings.Contains(err.Error(), "missing-control") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestBuild_debDirectives(t *testing.T) {
+	tarfile := writeTarfile(t, `
+COPY a-dir etc/hello
+POSTINST a-dir/b.txt
+`)
+
+	for _, output := range []Output{OutputTar, OutputCPIO, OutputZIP} {
+		err := Build(ioutil.Discard, "testdata", tarfile, WithOutput(output))
+		if err != errDeb {
+			t.Errorf("%q: expected %v but got %v", output, errDeb, err)
+		}
+	}
+
+	_, err := BuildLayers("testdata", tarfile)
+	if err != errDeb {
+		t.Errorf("layers: expected %v but got %v", errDeb, err)
+	}
+}
+
+// readAr returns the members of an ar archive by name.
+func readAr(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+
+	if !bytes.HasPrefix(data, []byte("!<arch>\n")) {
+		t.Fatal("missing ar magic")
+	}
+	data = data[8:]
+
+	members := map[string][]byte{}
+	for len(data) > 0 {
+		if len(data) < 60 {
+			t.Fatalf("truncated ar header")
+		}
+		name := strings.TrimSpace(string(data[:16]))
+		size, err := strconv.Atoi(strings.TrimSpace(string(data[48:58])))
+		if err != nil {
+			t.Fatal(err)
+		}
+		members[name] = data[60 : 60+size]
+		data = data[60+size+size%2:]
+	}
+
+	return members
+}
+
+// readTarGz returns the contents of a compressed tar archive by name.
+func readTarGz(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+
+	z, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{}
+	r := tar.NewReader(z)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[h.Name], _ = ioutil.ReadAll(r)
+	}
+
+	return files
+}
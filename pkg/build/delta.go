@@ -0,0 +1,109 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// opaqueWhiteout hides all the entries of the directory it is in.
+const opaqueWhiteout = whiteoutPrefix + whiteoutPrefix + ".opq"
+
+// Delta returns the changes from prev to cur: the entries which were added or
+// changed, the directories containing them and OCI whiteouts (.wh.<name>)
+// for the entries which were removed.
+func Delta(prev, cur *Dir) *Dir {
+	return diffTree(prev, cur)
+}
+
+// ApplyDelta overlays the entries of delta onto base. Whiteouts remove the
+// entries they name from base, opaque whiteouts (.wh..wh..opq) remove all the
+// entries of their directory.
+func ApplyDelta(base, delta *Dir) error {
+	err := applyDelta(base, delta)
+	if err != nil {
+		return err
+	}
+	base.BakeDeepEntries()
+	return nil
+}
+
+func applyDelta(base, delta *Dir) error {
+	// Whiteouts only hide the entries of the base, so they go first.
+	for _, e := range delta.Entries {
+		name := e.Name()
+		switch {
+		case name == opaqueWhiteout:
+			base.Entries = nil
+		case strings.HasPrefix(name, whiteoutPrefix):
+			err := base.Remove(strings.TrimPrefix(name, whiteoutPrefix))
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	for _, e := range delta.Entries {
+		if strings.HasPrefix(e.Name(), whiteoutPrefix) {
+			continue
+		}
+
+		cur, _ := base.GetEntry(e.Name())
+		dir, isDir := e.(*Dir)
+		curDir, wasDir := cur.(*Dir)
+
+		if isDir && wasDir {
+			// Take the metadata of the delta but keep the entries.
+			entries := curDir.Entries
+			*curDir = *dir
+			curDir.Entries, curDir.DeepEntries = entries, nil
+			err := applyDelta(curDir, dir)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if cur != nil {
+			err := base.Remove(e.Name())
+			if err != nil {
+				return err
+			}
+		}
+
+		if !isDir {
+			base.Entries = append(base.Entries, e.clone(e.Name()))
+			continue
+		}
+
+		newDir := dir.clone(dir.name).(*Dir)
+		newDir.Entries = nil
+		err := applyDelta(newDir, dir)
+		if err != nil {
+			return err
+		}
+		base.Entries = append(base.Entries, newDir)
+	}
+
+	sort.Sort(base)
+	return nil
+}
+
+// WriteTar writes the entries of d as a tar archive. Entries keep their
+// modification times; those without one get the fixed time.
+func WriteTar(dst io.Writer, d *Dir) error {
+	w := &tarWriter{
+		Writer:          tar.NewWriter(dst),
+		modTime:         ftime,
+		preserveModTime: true,
+	}
+
+	err := d.writeEntriesToTar("", w)
+	if err != nil {
+		return err
+	}
+
+	return w.Close()
+}
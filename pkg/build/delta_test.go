@@ -0,0 +1,86 @@
+package tarbuild
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestApplyDelta(t *testing.T) {
+	load := func(tarfile string) *Dir {
+		var buf bytes.Buffer
+		err := Build(&buf, "testdata", writeTarfile(t, tarfile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := NewDirFromTar(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	base := load(`
+COPY a-dir data
+MKDIR old/sub
+TESTSYMLINK a.txt data/link
+`)
+	cur := load(`
+COPY a-dir/a.txt data/
+CHMOD 0600 data/a.txt
+MKDIR old
+COPY a-dir/b.txt data/link
+MKDIR new/sub
+`)
+
+	var buf bytes.Buffer
+	err := WriteTar(&buf, Delta(base, cur))
+	if err != nil {
+		t.Fatal(err)
+	}
+	delta, err := NewDirFromTar(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"data/.wh.b.txt", "old/.wh.sub", "data/link", "new/sub"} {
+		if _, err := delta.GetEntry(name); err != nil {
+			t.Errorf("expected %s in the delta: %v", name, err)
+		}
+	}
+
+	err = ApplyDelta(base, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := DiffDirs(base, cur)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes but got %v", changes)
+	}
+}
+
+func TestApplyDeltaOpaque(t *testing.T) {
+	base := NewDir()
+	base.AddFile("dir/a.txt", "")
+	base.AddFile("dir/b.txt", "")
+
+	delta := NewDir()
+	delta.AddFile("dir/"+opaqueWhiteout, "")
+	delta.AddFile("dir/c.txt", "")
+
+	err := ApplyDelta(base, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := base.GetDir("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dir.Entries) != 1 || dir.Entries[0].Name() != "c.txt" {
+		t.Errorf("expected only c.txt but got %v", dir.Entries)
+	}
+}
@@ -0,0 +1,189 @@
+package tarbuild
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChangeKind is the kind of a Change.
+type ChangeKind string
+
+const (
+	Added   ChangeKind = "added"
+	Removed ChangeKind = "removed"
+
+	// ContentChanged is used when the contents, the type, the link target or
+	// the device numbers of an entry changed. Metadata may have changed too.
+	ContentChanged ChangeKind = "content"
+
+	// MetadataChanged is used when only the mode, the owner, the modification
+	// time or the extended attributes of an entry changed.
+	MetadataChanged ChangeKind = "metadata"
+)
+
+// Change is a difference between two trees.
+type Change struct {
+	Path string     `json:"path"`
+	Kind ChangeKind `json:"kind"`
+
+	// Metadata holds the old and new values of the changed fields of a
+	// modified entry.
+	Metadata map[string][2]string `json:"metadata,omitempty"`
+}
+
+func (c Change) String() string {
+	if len(c.Metadata) == 0 {
+		return fmt.Sprintf("%-8s  %s", c.Kind, c.Path)
+	}
+
+	var (
+		fields []string
+		keys   []string
+	)
+	for k := range c.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fields = append(fields, fmt.Sprintf("%s %s -> %s", k, c.Metadata[k][0], c.Metadata[k][1]))
+	}
+
+	return fmt.Sprintf("%-8s  %s (%s)", c.Kind, c.Path, strings.Join(fields, ", "))
+}
+
+// DiffDirs compares two trees and returns the changes from a to b, sorted by
+// path.
+func DiffDirs(a, b *Dir) ([]Change, error) {
+	entriesA, err := entriesByPath(a)
+	if err != nil {
+		return nil, err
+	}
+	entriesB, err := entriesByPath(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for p := range entriesA {
+		paths = append(paths, p)
+	}
+	for p := range entriesB {
+		if _, found := entriesA[p]; !found {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+
+	// Entries keep their own times; the writer is only needed for the
+	// headers.
+	w := &tarWriter{preserveModTime: true}
+
+	var changes []Change
+	for _, p := range paths {
+		ea, inA := entriesA[p]
+		eb, inB := entriesB[p]
+
+		switch {
+		case !inB:
+			changes = append(changes, Change{Path: p, Kind: Removed})
+		case !inA:
+			changes = append(changes, Change{Path: p, Kind: Added})
+		default:
+			c, err := diffEntries(p, ea, eb, w)
+			if err != nil {
+				return nil, err
+			}
+			if c != nil {
+				changes = append(changes, *c)
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+func entriesByPath(d *Dir) (map[string]Entry, error) {
+	entries := map[string]Entry{}
+	err := d.walk("", func(path string, e Entry) error {
+		entries[path] = e
+		return nil
+	})
+	return entries, err
+}
+
+// diffEntries compares two entries at the same path. It returns nil when
+// they are the same.
+func diffEntries(path string, a, b Entry, w *tarWriter) (*Change, error) {
+	ha, err := a.tarHeader(path, w)
+	if err != nil {
+		return nil, err
+	}
+	hb, err := b.tarHeader(path, w)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Change{Path: path, Kind: MetadataChanged, Metadata: map[string][2]string{}}
+
+	set := func(field, from, to string) {
+		if from != to {
+			c.Metadata[field] = [2]string{from, to}
+		}
+	}
+
+	set("type", mtreeTypes[ha.Typeflag], mtreeTypes[hb.Typeflag])
+	set("mode", fmt.Sprintf("%04o", ha.Mode&07777), fmt.Sprintf("%04o", hb.Mode&07777))
+	set("owner", ha.Uname+":"+ha.Gname, hb.Uname+":"+hb.Gname)
+	set("mtime", ha.ModTime.UTC().Format(time.RFC3339Nano), hb.ModTime.UTC().Format(time.RFC3339Nano))
+	set("xattrs", formatXattrs(ha.PAXRecords), formatXattrs(hb.PAXRecords))
+	set("link", ha.Linkname, hb.Linkname)
+	set("device", fmt.Sprintf("%d,%d", ha.Devmajor, ha.Devminor), fmt.Sprintf("%d,%d", hb.Devmajor, hb.Devminor))
+
+	content := false
+	for _, field := range []string{"type", "link", "device"} {
+		if _, found := c.Metadata[field]; found {
+			content = true
+		}
+	}
+
+	fa, okA := a.(*File)
+	fb, okB := b.(*File)
+	if okA && okB {
+		da, err := fa.readData()
+		if err != nil {
+			return nil, err
+		}
+		db, err := fb.readData()
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(da, db) {
+			content = true
+		}
+	}
+
+	if content {
+		c.Kind = ContentChanged
+	} else if len(c.Metadata) == 0 {
+		return nil, nil
+	}
+	if len(c.Metadata) == 0 {
+		c.Metadata = nil
+	}
+
+	return c, nil
+}
+
+func formatXattrs(records map[string]string) string {
+	var xattrs []string
+	for k, v := range records {
+		if strings.HasPrefix(k, paxXattrPrefix) {
+			xattrs = append(xattrs, fmt.Sprintf("%s=%q", strings.TrimPrefix(k, paxXattrPrefix), v))
+		}
+	}
+	sort.Strings(xattrs)
+	return "{" + strings.Join(xattrs, " ") + "}"
+}
@@ -0,0 +1,58 @@
+package tarbuild
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDiffDirs(t *testing.T) {
+	load := func(tarfile string) *Dir {
+		var buf bytes.Buffer
+		err := Build(&buf, "testdata", writeTarfile(t, tarfile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := NewDirFromTar(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	a := load(`
+COPY a-dir data
+CHMOD 0644 data/a.txt
+TESTSYMLINK a.txt data/link
+MKDIR old
+`)
+	b := load(`
+COPY a-dir data
+CHMOD 0600 data/a.txt
+TESTSYMLINK b.txt data/link
+MKDIR new
+`)
+
+	changes, err := DiffDirs(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Change{
+		{Path: "data/a.txt", Kind: MetadataChanged, Metadata: map[string][2]string{"mode": {"0644", "0600"}}},
+		{Path: "data/link", Kind: ContentChanged, Metadata: map[string][2]string{"link": {"a.txt", "b.txt"}}},
+		{Path: "new", Kind: Added},
+		{Path: "old", Kind: Removed},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %v but got %v", expected, changes)
+	}
+
+	changes, err = DiffDirs(a, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("expected no changes but got %v", changes)
+	}
+}
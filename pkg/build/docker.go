@@ -0,0 +1,283 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// BuildDockerImage builds the Tarfile and writes it as an image tarball in
+// the docker save format, which can be read by docker load. ref is the name
+// of the image, with an optional tag.
+func BuildDockerImage(dst io.Writer, ref, wd, conf string, img ImageConfig, opts ...Option) error {
+	return BuildDockerImageContext(context.Background(), dst, ref, wd, conf, img, opts...)
+}
+
+// BuildDockerImageContext is like BuildDockerImage but stops with the error
+// of ctx when ctx is done, like BuildContext.
+func BuildDockerImageContext(ctx context.Context, dst io.Writer, ref, wd, conf string, img ImageConfig, opts ...Option) error {
+	r, err := build(ctx, wd, conf, opts)
+	if err != nil {
+		return err
+	}
+
+	layers, err := r.layers()
+	if err != nil {
+		return err
+	}
+
+	im, err := newImage(r.mtime, img, layers)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+
+	err = writeDockerImage(&buf, ref, im, r.mtime)
+	if err != nil {
+		return err
+	}
+
+	_, err = buf.WriteTo(dst)
+	return err
+}
+
+type dockerManifest struct {
+	Config   string
+	RepoTags []string
+	Layers   []string
+}
+
+// parseImageRef splits a reference in its name and tag. The tag defaults to
+// latest.
+func parseImageRef(ref string) (name, tag string, err error) {
+	name, tag = ref, "latest"
+
+	if idx := strings.LastIndexByte(ref, ':'); idx > strings.LastIndexByte(ref, '/') {
+		name, tag = ref[:idx], ref[idx+1:]
+	}
+
+	if name == "" || tag == "" || strings.ContainsAny(ref, "@ ") {
+		return "", "", fmt.Errorf("invalid image reference %q: expected name[:tag]", ref)
+	}
+
+	return name, tag, nil
+}
+
+func writeDockerImage(dst io.Writer, ref string, im *image, mtime time.Time) error {
+	name, tag, err := parseImageRef(ref)
+	if err != nil {
+		return err
+	}
+
+	var (
+		w        = tar.NewWriter(dst)
+		manifest = dockerManifest{RepoTags: []string{name + ":" + tag}}
+		parent   string
+	)
+
+	writeFile := func(name string, data []byte) error {
+		err := w.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Mode:     0644,
+			Size:     int64(len(data)),
+			ModTime:  mtime,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+
+	for _, layer := range im.layers {
+		// The legacy layer IDs only have to be unique; derive them from the
+		// chain of layers so they are stable.
+		sum := sha256.Sum256([]byte(parent + "\n" + sha256Digest(layer)))
+		id := hex.EncodeToString(sum[:])
+
+		err := w.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeDir,
+			Name:     id + "/",
+			Mode:     0755,
+			ModTime:  mtime,
+		})
+		if err != nil {
+			return err
+		}
+
+		legacy := map[string]string{"id": id}
+		if parent != "" {
+			legacy["parent"] = parent
+		}
+		legacyData, err := json.Marshal(legacy)
+		if err != nil {
+			return err
+		}
+
+		err = writeFile(id+"/VERSION", []byte("1.0"))
+		if err != nil {
+			return err
+		}
+		err = writeFile(id+"/json", legacyData)
+		if err != nil {
+			return err
+		}
+		err = writeFile(id+"/layer.tar", layer)
+		if err != nil {
+			return err
+		}
+
+		manifest.Layers = append(manifest.Layers, id+"/layer.tar")
+		parent = id
+	}
+
+	manifest.Config = strings.TrimPrefix(sha256Digest(im.config), "sha256:") + ".json"
+	err = writeFile(manifest.Config, im.config)
+	if err != nil {
+		return err
+	}
+
+	manifestData, err := json.Marshal([]dockerManifest{manifest})
+	if err != nil {
+		return err
+	}
+	err = writeFile("manifest.json", manifestData)
+	if err != nil {
+		return err
+	}
+
+	repositories, err := json.Marshal(map[string]map[string]string{name: {tag: parent}})
+	if err != nil {
+		return err
+	}
+	err = writeFile("repositories", repositories)
+	if err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+// loadDockerImage reads an image tarball in the docker save format. The
+// tarball may be gzip compressed.
+func loadDockerImage(name string) (*image, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r, err := maybeGunzip(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("base image %s: %v", name, err)
+	}
+
+	var (
+		files = map[string][]byte{}
+
+		// links maps symbolic and hard links to the files they point to.
+		// Older versions of docker save store the duplicate layer.tar files
+		// as symbolic links.
+		links = map[string]string{}
+	)
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("base image %s: %v", name, err)
+		}
+
+		entryName := path.Clean(h.Name)
+		switch h.Typeflag {
+		case tar.TypeReg:
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("base image %s: %v", name, err)
+			}
+			files[entryName] = data
+		case tar.TypeSymlink:
+			links[entryName] = path.Join(path.Dir(entryName), h.Linkname)
+		case tar.TypeLink:
+			links[entryName] = path.Clean(h.Linkname)
+		}
+	}
+
+	// lookup returns the contents of a file, following links.
+	lookup := func(file string) ([]byte, bool) {
+		file = path.Clean(file)
+		for i := 0; i < maxSymlinks; i++ {
+			target, found := links[file]
+			if !found {
+				break
+			}
+			file = target
+		}
+		data, found := files[file]
+		return data, found
+	}
+
+	var manifests []dockerManifest
+	data, _ := lookup("manifest.json")
+	err = json.Unmarshal(data, &manifests)
+	if err != nil {
+		return nil, fmt.Errorf("base image %s: invalid manifest.json: %v", name, err)
+	}
+	if len(manifests) != 1 {
+		return nil, fmt.Errorf("base image %s: expected one image but found %d", name, len(manifests))
+	}
+
+	im := &image{}
+
+	config, found := lookup(manifests[0].Config)
+	if !found {
+		return nil, fmt.Errorf("base image %s: missing config %s", name, manifests[0].Config)
+	}
+	im.config = config
+
+	for _, l := range manifests[0].Layers {
+		data, found := lookup(l)
+		if !found {
+			return nil, fmt.Errorf("base image %s: missing layer %s", name, l)
+		}
+
+		r, err := maybeGunzip(bufio.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			return nil, fmt.Errorf("base image %s: layer %s: %v", name, l, err)
+		}
+		data, err = ioutil.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("base image %s: layer %s: %v", name, l, err)
+		}
+
+		im.layers = append(im.layers, data)
+	}
+
+	return im, nil
+}
+
+// maybeGunzip decompresses r when it starts with the gzip magic number.
+func maybeGunzip(r *bufio.Reader) (io.Reader, error) {
+	magic, err := r.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(r)
+	}
+	return r, nil
+}
@@ -0,0 +1,126 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestBuildDockerImage(t *testing.T) {
+	base, err := ioutil.TempFile("", "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(base.Name())
+
+	err = BuildDockerImage(base, "base", "testdata", "testdata/Tarfile", ImageConfig{
+		Entrypoint: []string{"/bin/sh"},
+		Env:        []string{"PATH=/bin", "A=1"},
+	})
+	base.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = BuildDockerImage(&buf, "registry:5000/app:v1", "testdata", "testdata/Tarfile", ImageConfig{
+		Base: base.Name(),
+		Env:  []string{"A=2", "B=3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{}
+	r := tar.NewReader(&buf)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(r)
+		files[h.Name] = data
+	}
+
+	var manifests []dockerManifest
+	mustUnmarshal(t, files["manifest.json"], &manifests)
+	if len(manifests) != 1 || len(manifests[0].Layers) != 2 {
+		t.Fatalf("unexpected manifest %s", files["manifest.json"])
+	}
+	if !reflect.DeepEqual(manifests[0].RepoTags, []string{"registry:5000/app:v1"}) {
+		t.Errorf("unexpected tags %q", manifests[0].RepoTags)
+	}
+
+	var config struct {
+		Config struct {
+			Entrypoint []string
+			Env        []string
+		}
+		RootFS imageRootFS
+	}
+	mustUnmarshal(t, files[manifests[0].Config], &config)
+
+	if !reflect.DeepEqual(config.Config.Entrypoint, []string{"/bin/sh"}) {
+		t.Errorf("expected the entrypoint of the base image but got %q", config.Config.Entrypoint)
+	}
+	if !reflect.DeepEqual(config.Config.Env, []string{"PATH=/bin", "A=2", "B=3"}) {
+		t.Errorf("unexpected env %q", config.Config.Env)
+	}
+	for i, l := range manifests[0].Layers {
+		if config.RootFS.DiffIDs[i] != sha256Digest(files[l]) {
+			t.Errorf("diff_id %d doesn't match %s", i, l)
+		}
+	}
+}
+
+func TestLoadDockerImage_symlinkedLayers(t *testing.T) {
+	f, err := ioutil.TempFile("", "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	var (
+		layer    = []byte("not really a layer")
+		manifest = []byte(`[{"Config":"config.json","Layers":["a/layer.tar","b/layer.tar"]}]`)
+		w        = tar.NewWriter(f)
+	)
+	for _, file := range []struct {
+		name string
+		data []byte
+	}{
+		{"manifest.json", manifest},
+		{"config.json", []byte("{}")},
+		{"a/layer.tar", layer},
+	} {
+		err := w.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: file.name, Size: int64(len(file.data))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(file.data)
+	}
+	err = w.WriteHeader(&tar.Header{Typeflag: tar.TypeSymlink, Name: "b/layer.tar", Linkname: "../a/layer.tar"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := loadDockerImage(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(im.layers) != 2 || !bytes.Equal(im.layers[1], layer) {
+		t.Errorf("expected the symlinked layer to be resolved but got %q", im.layers)
+	}
+}
@@ -0,0 +1,55 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"fmt"
+)
+
+// Format is the header format of the archive.
+type Format string
+
+const (
+	// FormatDefault lets archive/tar pick the format of each header. It uses
+	// USTAR when possible and falls back to PAX or GNU when a header needs it.
+	FormatDefault Format = ""
+
+	// FormatUSTAR only writes POSIX.1-1988 headers. Paths longer than 255
+	// bytes, names longer than 32 bytes and non-ASCII strings are rejected.
+	FormatUSTAR Format = "ustar"
+
+	// FormatPAX writes POSIX.1-2001 headers with extended records where
+	// needed.
+	FormatPAX Format = "pax"
+
+	// FormatGNU writes GNU tar headers.
+	FormatGNU Format = "gnu"
+)
+
+// ParseFormat parses the name of a format.
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(name); f {
+	case FormatDefault, FormatUSTAR, FormatPAX, FormatGNU:
+		return f, nil
+	}
+	return FormatDefault, fmt.Errorf("invalid format %q: expected ustar, pax or gnu", name)
+}
+
+// WithFormat sets the header format of the archive.
+func WithFormat(f Format) Option {
+	return func(c *buildConfig) {
+		c.format = f
+	}
+}
+
+func (f Format) tarFormat() tar.Format {
+	switch f {
+	case FormatUSTAR:
+		return tar.FormatUSTAR
+	case FormatPAX:
+		return tar.FormatPAX
+	case FormatGNU:
+		return tar.FormatGNU
+	default:
+		return tar.FormatUnknown
+	}
+}
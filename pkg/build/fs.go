@@ -0,0 +1,399 @@
+package tarbuild
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// WithContextFS uses fsys as the build context, like an embed.FS or a
+// fstest.MapFS. The context directory passed to Build is ignored then and
+// WithContextXattrs is rejected.
+func WithContextFS(fsys fs.FS) Option {
+	return func(c *buildConfig) {
+		c.contextFS = fsys
+	}
+}
+
+// readLinkFS is implemented by file systems which can read symlinks.
+type readLinkFS interface {
+	ReadLink(name string) (string, error)
+}
+
+// NewDirFromFS loads a tree from fsys. The files are read from fsys when the
+// archive is written. CaptureSymlinks requires fsys to have a ReadLink
+// method; io/fs has no extended attributes so CaptureXattrs is rejected.
+func NewDirFromFS(fsys fs.FS, opts ...ScanOption) (*Dir, error) {
+	var cfg scanConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.xattrs {
+		return nil, fmt.Errorf("the context can't read extended attributes, it isn't a directory")
+	}
+
+	rl, ok := fsys.(readLinkFS)
+	if cfg.symlinks && !ok {
+		return nil, fmt.Errorf("the context can't read symbolic links, it has no ReadLink method")
+	}
+
+	rootDir := NewDir()
+
+	add := func(name string, d fs.DirEntry) error {
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case fi.IsDir():
+			dir, err := rootDir.MkdirAll(name)
+			if err != nil {
+				return err
+			}
+
+			dir.Perm = fi.Mode().Perm()
+			dir.ModTime = cfg.modTime(fi)
+
+		case fi.Mode().IsRegular():
+			file, err := rootDir.AddFile(name, name)
+			if err != nil {
+				return err
+			}
+
+			file.fsys = fsys
+			file.Perm = fi.Mode().Perm()
+			file.ModTime = cfg.modTime(fi)
+
+		case cfg.symlinks && fi.Mode()&fs.ModeSymlink != 0:
+			target, err := rl.ReadLink(name)
+			if err != nil {
+				return err
+			}
+
+			link, err := rootDir.AddSymlink(name, target)
+			if err != nil {
+				return err
+			}
+
+			link.ModTime = cfg.modTime(fi)
+		}
+
+		return nil
+	}
+
+	var reversed []func() error
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := cfg.err(); err != nil {
+			return err
+		}
+		if name == "." {
+			return nil
+		}
+
+		if cfg.reverse {
+			reversed = append(reversed, func() error { return add(name, d) })
+			return nil
+		}
+		return add(name, d)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(reversed) - 1; i >= 0; i-- {
+		err := reversed[i]()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rootDir, nil
+}
+
+// maxSymlinks is the number of symlinks followed before giving up on a path.
+const maxSymlinks = 40
+
+var (
+	errIsDir        = errors.New("is a directory")
+	errTooManyLinks = errors.New("too many levels of symbolic links")
+)
+
+// FS returns the tree of d as a file system, following symlinks. It
+// implements fs.FS, fs.ReadDirFS, fs.StatFS and fs.ReadFileFS so a tree can
+// be used with http.FS, template.ParseFS or fs.WalkDir. A *Dir can't be an
+// fs.FS itself, as its Open method is the one of Entry.
+//
+// The FileInfo reports the virtual permissions and times of the entries. It
+// has an Owner method, like Entry, and its Sys method returns the Entry:
+//
+//	fi, _ := fs.Stat(d.FS(), "etc/passwd")
+//	user, group := fi.(interface{ Owner() (string, string) }).Owner()
+func (d *Dir) FS() fs.FS {
+	return dirFS{d}
+}
+
+// dirFS is the file system returned by Dir.FS.
+type dirFS struct {
+	dir *Dir
+}
+
+// Open opens the entry at name, following symlinks.
+func (f dirFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	e, err := f.dir.resolve(name, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	info := newFileInfo(path.Base(name), e)
+
+	switch e := e.(type) {
+	case *Dir:
+		return &openDir{info: info, dir: e}, nil
+	case *File:
+		data, err := e.readData()
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		info.size = int64(len(data))
+		return &openFile{Reader: bytes.NewReader(data), info: info}, nil
+	default:
+		return &openFile{Reader: bytes.NewReader(nil), info: info}, nil
+	}
+}
+
+// Stat returns the FileInfo of the entry at name, following symlinks.
+func (f dirFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	e, err := f.dir.resolve(name, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+	}
+
+	info := newFileInfo(path.Base(name), e)
+	if file, ok := e.(*File); ok {
+		info.size, err = file.size()
+		if err != nil {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+		}
+	}
+	return info, nil
+}
+
+// ReadDir returns the entries of the directory at name, sorted by name.
+func (f dirFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	e, err := f.dir.resolve(name, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	dir, ok := e.(*Dir)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	return dir.dirEntries(), nil
+}
+
+// ReadFile returns a copy of the contents of the file at name, following
+// symlinks. Device nodes and FIFOs read as empty files.
+func (f dirFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+
+	e, err := f.dir.resolve(name, true)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+
+	switch e := e.(type) {
+	case *File:
+		data, err := e.readData()
+		if err != nil {
+			return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+		}
+		// The data of files made by commands belongs to the tree.
+		return append([]byte(nil), data...), nil
+	case *Dir:
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDir}
+	default:
+		return []byte{}, nil
+	}
+}
+
+func (d *Dir) dirEntries() []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(d.Entries))
+	for _, e := range d.Entries {
+		entries = append(entries, newFileInfo(e.Name(), e))
+	}
+	return entries
+}
+
+// resolve returns the entry at name, a clean relative path, following the
+// symlinks in the tree. The last element is only followed when follow is
+// set. Absolute link targets are relative to d and .. stops at d, like in a
+// chroot.
+func (d *Dir) resolve(name string, follow bool) (Entry, error) {
+	for hops := 0; ; hops++ {
+		if hops > maxSymlinks {
+			return nil, errTooManyLinks
+		}
+
+		e, rest, err := d.lookup(name, follow)
+		if err != nil || rest == "" {
+			return e, err
+		}
+		name = rest
+	}
+}
+
+// lookup walks name until it finds a symlink to follow. It returns the entry
+// when there is none, or the path to continue with.
+func (d *Dir) lookup(name string, follow bool) (Entry, string, error) {
+	if name == "." {
+		return d, "", nil
+	}
+
+	var (
+		cur   Entry = d
+		dir         = "."
+		elems       = strings.Split(name, "/")
+	)
+	for i, elem := range elems {
+		parent, ok := cur.(*Dir)
+		if !ok {
+			return nil, "", fs.ErrNotExist
+		}
+		e, err := parent.GetEntry(elem)
+		if err != nil {
+			return nil, "", fs.ErrNotExist
+		}
+
+		last := i == len(elems)-1
+		if l, ok := e.(*Symlink); ok && (!last || follow) {
+			target := l.Target
+			if !path.IsAbs(target) {
+				target = path.Join(dir, target)
+			}
+			rest := path.Join(append([]string{"/", target}, elems[i+1:]...)...)
+			return nil, path.Join(".", rest), nil
+		}
+
+		cur = e
+		dir = path.Join(dir, elem)
+	}
+
+	return cur, "", nil
+}
+
+// fileInfo describes an entry for io/fs. It is both a FileInfo and a
+// DirEntry.
+type fileInfo struct {
+	name  string
+	size  int64
+	entry Entry
+}
+
+func newFileInfo(name string, e Entry) *fileInfo {
+	if name == "/" {
+		name = "."
+	}
+	info := &fileInfo{name: name, entry: e}
+	if f, ok := e.(*File); ok {
+		info.size, _ = f.size()
+	}
+	return info
+}
+
+func (fi *fileInfo) Name() string      { return fi.name }
+func (fi *fileInfo) Size() int64       { return fi.size }
+func (fi *fileInfo) Mode() fs.FileMode { return fi.entry.Mode() }
+func (fi *fileInfo) IsDir() bool       { return fi.entry.isDir() }
+func (fi *fileInfo) Sys() interface{}  { return fi.entry }
+
+// Owner returns the user and group names of the entry.
+func (fi *fileInfo) Owner() (user, group string) { return fi.entry.Owner() }
+
+func (fi *fileInfo) Type() fs.FileMode          { return fi.entry.Type() }
+func (fi *fileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+
+func (fi *fileInfo) ModTime() time.Time {
+	switch e := fi.entry.(type) {
+	case *Dir:
+		return e.ModTime
+	case *File:
+		return e.ModTime
+	case *Symlink:
+		return e.ModTime
+	case *Node:
+		return e.ModTime
+	default:
+		return time.Time{}
+	}
+}
+
+// openFile is an open file, symlink or node. The contents are read when it
+// is opened, so it can seek.
+type openFile struct {
+	*bytes.Reader
+	info *fileInfo
+}
+
+func (f *openFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *openFile) Close() error               { return nil }
+
+// openDir is an open directory.
+type openDir struct {
+	info    *fileInfo
+	dir     *Dir
+	entries []fs.DirEntry
+	offset  int
+}
+
+func (d *openDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *openDir) Close() error               { return nil }
+
+func (d *openDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errIsDir}
+}
+
+func (d *openDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if d.entries == nil {
+		d.entries = d.dir.dirEntries()
+	}
+
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.offset += n
+	return rest[:n], nil
+}
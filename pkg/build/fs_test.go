@@ -0,0 +1,119 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/fs"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestDirFS(t *testing.T) {
+	tarfile := `
+COPY a-dir data
+CHOWN app:staff data/a.txt
+TESTSYMLINK ../data/a.txt links/a.txt
+TESTSYMLINK /data links/data
+MKFIFO pipe
+`
+
+	r, err := build(context.Background(), "testdata", writeTarfile(t, tarfile), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := r.tree.FS()
+
+	err = fstest.TestFS(fsys, "data/a.txt", "data/b.txt", "links/a.txt", "pipe")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := fs.Stat(fsys, "links/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, group := fi.(interface{ Owner() (string, string) }).Owner()
+	if fi.Name() != "a.txt" || user != "app" || group != "staff" {
+		t.Errorf("unexpected file info %s %s:%s", fi.Name(), user, group)
+	}
+	if entry := fi.Sys().(Entry); fi.Mode() != entry.Mode() {
+		t.Errorf("expected mode %v but got %v", entry.Mode(), fi.Mode())
+	}
+
+	entries, err := fs.ReadDir(fsys, "links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Type() != fs.ModeSymlink {
+		t.Errorf("expected two symlinks but got %v", entries)
+	}
+
+	_, err = fsys.Open("links/../data")
+	if err == nil {
+		t.Error("expected an error for an invalid path")
+	}
+}
+
+func TestNewDirFromFS(t *testing.T) {
+	mtime := time.Date(2020, time.May, 18, 0, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"bin":          {Mode: fs.ModeDir | 0755, ModTime: mtime},
+		"bin/app":      {Data: []byte("#!/bin/sh\n"), Mode: 0755, ModTime: mtime},
+		"etc/app.conf": {Data: []byte("debug = false\n"), Mode: 0600, ModTime: mtime},
+	}
+
+	var buf bytes.Buffer
+	err := Build(&buf, "", writeTarfile(t, "COPY bin etc /\n"), WithContextFS(fsys), WithPreservedModTime())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var headers []string
+	tr := tar.NewReader(&buf)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers = append(headers, h.Name+" "+fs.FileMode(h.Mode).Perm().String())
+
+		if h.Name == "bin/app" {
+			data, _ := io.ReadAll(tr)
+			if string(data) != "#!/bin/sh\n" || !h.ModTime.Equal(mtime) {
+				t.Errorf("unexpected bin/app %q %v", data, h.ModTime)
+			}
+		}
+	}
+
+	expected := []string{
+		"bin/ -rwxr-xr-x",
+		"bin/app -rwxr-xr-x",
+		"etc/ -r-xr-xr-x",
+		"etc/app.conf -rw-------",
+	}
+	if !reflect.DeepEqual(headers, expected) {
+		t.Errorf("expected %q but got %q", expected, headers)
+	}
+}
+
+func TestNewDirFromFS_xattrs(t *testing.T) {
+	fsys := fstest.MapFS{"a.txt": {Data: []byte("a")}}
+
+	_, err := NewDirFromFS(fsys, CaptureXattrs())
+	if err == nil {
+		t.Error("expected an error for CaptureXattrs")
+	}
+
+	err = Build(io.Discard, "", writeTarfile(t, "COPY a.txt /\n"), WithContextFS(fsys), WithContextXattrs())
+	if err == nil {
+		t.Error("expected an error for WithContextXattrs")
+	}
+}
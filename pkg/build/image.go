@@ -0,0 +1,180 @@
+package tarbuild
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// image is a container image: its config and its uncompressed layers.
+type image struct {
+	config []byte
+	layers [][]byte
+}
+
+type imageRootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+type imageHistory struct {
+	Created   time.Time `json:"created"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+}
+
+// newImage stacks layers on the base image of img (if any) and merges the
+// settings of img into the config. Fields of the base config which are not
+// touched are kept as is.
+func newImage(created time.Time, img ImageConfig, layers []Layer) (*image, error) {
+	var (
+		im     = &image{}
+		config = map[string]json.RawMessage{}
+	)
+
+	if img.Base != "" {
+		base, err := loadDockerImage(img.Base)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(base.config, &config)
+		if err != nil {
+			return nil, err
+		}
+
+		im.layers = append(im.layers, base.layers...)
+	}
+	for _, l := range layers {
+		im.layers = append(im.layers, l.Data)
+	}
+
+	var (
+		containerConfig = map[string]json.RawMessage{}
+		rootFS          = imageRootFS{Type: "layers"}
+		history         []json.RawMessage
+	)
+
+	err := unmarshalField(config, "config", &containerConfig)
+	if err != nil {
+		return nil, err
+	}
+	err = unmarshalField(config, "rootfs", &rootFS)
+	if err != nil {
+		return nil, err
+	}
+	err = unmarshalField(config, "history", &history)
+	if err != nil {
+		return nil, err
+	}
+
+	if img.User != "" {
+		setField(containerConfig, "User", img.User)
+	}
+	if img.WorkingDir != "" {
+		setField(containerConfig, "WorkingDir", img.WorkingDir)
+	}
+	if len(img.Entrypoint) > 0 {
+		setField(containerConfig, "Entrypoint", img.Entrypoint)
+	}
+
+	if len(img.Env) > 0 {
+		var env []string
+		err := unmarshalField(containerConfig, "Env", &env)
+		if err != nil {
+			return nil, err
+		}
+		setField(containerConfig, "Env", mergeEnv(env, img.Env))
+	}
+
+	if len(img.Labels) > 0 {
+		var labels map[string]string
+		err := unmarshalField(containerConfig, "Labels", &labels)
+		if err != nil {
+			return nil, err
+		}
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		for k, v := range img.Labels {
+			labels[k] = v
+		}
+		setField(containerConfig, "Labels", labels)
+	}
+
+	for _, l := range layers {
+		rootFS.DiffIDs = append(rootFS.DiffIDs, sha256Digest(l.Data))
+
+		h, err := json.Marshal(imageHistory{
+			Created:   created.UTC(),
+			CreatedBy: "x-tar build",
+			Comment:   l.Name,
+		})
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, h)
+	}
+
+	setField(config, "created", created.UTC())
+	setField(config, "config", containerConfig)
+	setField(config, "rootfs", rootFS)
+	setField(config, "history", history)
+
+	if img.OS != "" {
+		setField(config, "os", img.OS)
+	} else if _, found := config["os"]; !found {
+		setField(config, "os", "linux")
+	}
+	if img.Architecture != "" {
+		setField(config, "architecture", img.Architecture)
+	} else if _, found := config["architecture"]; !found {
+		setField(config, "architecture", "amd64")
+	}
+
+	im.config, err = json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return im, nil
+}
+
+func unmarshalField(m map[string]json.RawMessage, key string, v interface{}) error {
+	data, found := m[key]
+	if !found || string(data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, v)
+}
+
+// setField sets a field to v. Values are plain data so they always marshal.
+func setField(m map[string]json.RawMessage, key string, v interface{}) {
+	data, _ := json.Marshal(v)
+	m[key] = data
+}
+
+// mergeEnv overrides the variables in env with those in override.
+func mergeEnv(env, override []string) []string {
+	merged := append([]string(nil), env...)
+
+	for _, kv := range override {
+		key := kv
+		if idx := strings.IndexByte(kv, '='); idx >= 0 {
+			key = kv[:idx]
+		}
+
+		replaced := false
+		for i, old := range merged {
+			if old == key || strings.HasPrefix(old, key+"=") {
+				merged[i] = kv
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, kv)
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,160 @@
+package tarbuild
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+func init() {
+	registerOp("LAYER", validateLAYER, nil)
+}
+
+// Layer is one layer of a Tarfile split in layers with LAYER commands:
+//
+//	LAYER [name]
+//
+// The commands before the first LAYER form the first layer. A layer only
+// holds the changes made by its commands; paths which were removed are
+// recorded as OCI whiteouts (.wh.<name>). Plain tar output is not affected.
+type Layer struct {
+	// Name is the argument of the LAYER command which started the layer.
+	Name string
+
+	// Data is the uncompressed tar archive of the changes made by the
+	// commands of the layer.
+	Data []byte
+}
+
+const whiteoutPrefix = ".wh."
+
+func validateLAYER(op *Op) error {
+	if len(op.Args) > 1 {
+		return fmt.Errorf("invalid command: %q takes at most a name", op.Name)
+	}
+	if len(op.Args) == 1 && strings.ContainsAny(op.Args[0], "/\\") {
+		return fmt.Errorf("invalid layer name %q", op.Args[0])
+	}
+	return nil
+}
+
+// BuildLayers builds the Tarfile and returns its layers. A Tarfile without
+// LAYER commands has a single layer.
+func BuildLayers(wd, conf string, opts ...Option) ([]Layer, error) {
+	return BuildLayersContext(context.Background(), wd, conf, opts...)
+}
+
+// BuildLayersContext is like BuildLayers but stops with the error of ctx when
+// ctx is done, like BuildContext.
+func BuildLayersContext(ctx context.Context, wd, conf string, opts ...Option) ([]Layer, error) {
+	r, err := build(ctx, wd, conf, opts)
+	if err != nil {
+		return nil, err
+	}
+	return r.layers()
+}
+
+// segment is the state of the tree at the end of a layer.
+type segment struct {
+	name string
+	tree *Dir
+}
+
+// layers diffs the segments of the build with the ones before them.
+func (r *result) layers() ([]Layer, error) {
+	var (
+		layers []Layer
+		prev   = NewDir()
+	)
+
+	for _, s := range r.segments {
+		var buf bytes.Buffer
+		err := r.writeTree(&buf, diffTree(prev, s.tree))
+		if err != nil {
+			return nil, err
+		}
+
+		layers = append(layers, Layer{Name: s.name, Data: buf.Bytes()})
+		prev = s.tree
+	}
+
+	return layers, nil
+}
+
+// deepCopy returns a copy of d which shares no entries with d, so it isn't
+// affected by later commands.
+func (d *Dir) deepCopy() *Dir {
+	dst := d.clone(d.name).(*Dir)
+	for i, e := range dst.Entries {
+		if dir, ok := e.(*Dir); ok {
+			dst.Entries[i] = dir.deepCopy()
+		} else {
+			dst.Entries[i] = e.clone(e.Name())
+		}
+	}
+	return dst
+}
+
+// diffTree returns the changes from prev to cur: the entries of cur which are
+// new or changed, the directories containing them and whiteouts for the
+// entries of prev which are gone.
+func diffTree(prev, cur *Dir) *Dir {
+	out := cur.clone(cur.name).(*Dir)
+	out.Entries = nil
+
+	for _, e := range cur.Entries {
+		p, _ := prev.GetEntry(e.Name())
+
+		dir, isDir := e.(*Dir)
+		prevDir, wasDir := p.(*Dir)
+
+		switch {
+		case isDir && wasDir:
+			sub := diffTree(prevDir, dir)
+			if len(sub.Entries) > 0 || !sameEntry(prevDir, dir) {
+				out.Entries = append(out.Entries, sub)
+			}
+		case p == nil || !sameEntry(p, e):
+			out.Entries = append(out.Entries, e.clone(e.Name()))
+		}
+	}
+
+	for _, p := range prev.Entries {
+		_, err := cur.GetEntry(p.Name())
+		if os.IsNotExist(err) {
+			out.Entries = append(out.Entries, &File{
+				name:  whiteoutPrefix + p.Name(),
+				Perm:  0644,
+				User:  "root",
+				Group: "root",
+			})
+		}
+	}
+
+	sort.Sort(out)
+	out.BakeDeepEntries()
+	return out
+}
+
+// sameEntry reports whether a and b are written the same way, ignoring the
+// entries of directories.
+func sameEntry(a, b Entry) bool {
+	da, ok := a.(*Dir)
+	if !ok {
+		return reflect.DeepEqual(a, b)
+	}
+
+	db, ok := b.(*Dir)
+	if !ok {
+		return false
+	}
+
+	x, y := *da, *db
+	x.Entries, x.DeepEntries = nil, nil
+	y.Entries, y.DeepEntries = nil, nil
+	return reflect.DeepEqual(x, y)
+}
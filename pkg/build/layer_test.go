@@ -0,0 +1,93 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestBuildLayers(t *testing.T) {
+	tarfile := `
+LAYER
+COPY a-dir data
+MKDIR empty
+LAYER app
+CHMOD 0600 data/a.txt
+TRANSFORM s,^data/b.txt$,data/c.txt, s,^empty/$,gone/,
+MKDIR data/sub
+LAYER
+`
+
+	layers, err := BuildLayers("testdata", writeTarfile(t, tarfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]string{
+		{"data/", "data/a.txt", "data/b.txt", "empty/"},
+		{".wh.empty", "data/", "data/.wh.b.txt", "data/a.txt", "data/c.txt", "data/sub/", "gone/"},
+	}
+	if len(layers) != len(expected) {
+		t.Fatalf("expected %d layers but got %d", len(expected), len(layers))
+	}
+	if layers[0].Name != "" || layers[1].Name != "app" {
+		t.Errorf("unexpected names %q and %q", layers[0].Name, layers[1].Name)
+	}
+
+	for i, l := range layers {
+		var names []string
+		r := tar.NewReader(bytes.NewReader(l.Data))
+		for {
+			h, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, h.Name)
+		}
+
+		if !reflect.DeepEqual(names, expected[i]) {
+			t.Errorf("layer %d: expected %q but got %q", i, expected[i], names)
+		}
+	}
+}
+
+func TestBuildLayers_ownerIDs(t *testing.T) {
+	tarfile := `
+COPY a-dir data
+CHOWN 1000:1001 data/a.txt
+CHOWN app:2000 data/b.txt
+`
+
+	layers, err := BuildLayers("testdata", writeTarfile(t, tarfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	owners := map[string]string{}
+	r := tar.NewReader(bytes.NewReader(layers[0].Data))
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		owners[h.Name] = fmt.Sprintf("%s(%d):%s(%d)", h.Uname, h.Uid, h.Gname, h.Gid)
+	}
+
+	expected := map[string]string{
+		"data/":      "root(0):root(0)",
+		"data/a.txt": "1000(1000):1001(1001)",
+		"data/b.txt": "app(0):2000(2000)",
+	}
+	if !reflect.DeepEqual(owners, expected) {
+		t.Errorf("expected %v but got %v", expected, owners)
+	}
+}
@@ -0,0 +1,168 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+func init() {
+	registerOp("MANIFEST", validateMANIFEST, nil)
+}
+
+// Manifest lists the entries of an archive.
+type Manifest struct {
+	Entries []ManifestEntry `json:"entries"`
+}
+
+// ManifestEntry describes an entry of an archive. SHA256 is only set for
+// files and Target only for symbolic links.
+type ManifestEntry struct {
+	Path   string `json:"path"`
+	Type   string `json:"type"`
+	Mode   string `json:"mode"`
+	Owner  string `json:"owner"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+	Target string `json:"target,omitempty"`
+}
+
+// WithManifest writes the manifest of the archive made by Build to dst, as
+// JSON. The digests are computed while the archive is written.
+func WithManifest(dst io.Writer) Option {
+	return func(c *buildConfig) {
+		c.manifest = dst
+	}
+}
+
+func validateMANIFEST(op *Op) error {
+	if len(op.Args) != 1 {
+		return fmt.Errorf("invalid command: %q requires a single path", op.Name)
+	}
+	return nil
+}
+
+// MANIFEST embeds the manifest in the archive:
+//
+//	MANIFEST <path>
+//
+// The manifest is written as the last entry and doesn't list itself. Missing
+// parent directories are created.
+func applyMANIFEST(r *result, dst *Dir, op Op) error {
+	if len(op.Args) != 1 {
+		return fmt.Errorf("usage: MANIFEST <path>")
+	}
+
+	name := path.Join(".", path.Join("/", op.Args[0]))
+	if name == "." {
+		return fmt.Errorf("usage: MANIFEST <path>")
+	}
+
+	_, err := dst.MkdirAll(path.Dir(name))
+	if err != nil {
+		return fmt.Errorf("MANIFEST %s: %v", op.Args[0], err)
+	}
+
+	r.manifestPath = name
+	return nil
+}
+
+var manifestTypes = map[byte]string{
+	tar.TypeReg:     "file",
+	tar.TypeDir:     "dir",
+	tar.TypeSymlink: "symlink",
+	tar.TypeChar:    "char",
+	tar.TypeBlock:   "block",
+	tar.TypeFifo:    "fifo",
+}
+
+// recordEntry adds the entry of h to the manifest. The digest of a file is
+// computed by Write as its contents are written.
+func (w *tarWriter) recordEntry(h *tar.Header) {
+	w.finishEntry()
+
+	w.manifest.Entries = append(w.manifest.Entries, ManifestEntry{
+		Path:   strings.TrimSuffix(h.Name, "/"),
+		Type:   manifestTypes[h.Typeflag],
+		Mode:   fmt.Sprintf("%04o", h.Mode&07777),
+		Owner:  h.Uname + ":" + h.Gname,
+		Size:   h.Size,
+		Target: h.Linkname,
+	})
+
+	if h.Typeflag == tar.TypeReg {
+		w.digest = sha256.New()
+	}
+}
+
+// finishEntry sets the digest of the last entry.
+func (w *tarWriter) finishEntry() {
+	if w.digest == nil {
+		return
+	}
+
+	last := &w.manifest.Entries[len(w.manifest.Entries)-1]
+	last.SHA256 = hex.EncodeToString(w.digest.Sum(nil))
+	w.digest = nil
+}
+
+func (w *tarWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	if w.digest != nil {
+		w.digest.Write(p[:n])
+	}
+	return n, err
+}
+
+// writeManifest embeds the manifest in the archive when MANIFEST is used and
+// writes it to the WithManifest writer.
+func (r *result) writeManifest(w *tarWriter) error {
+	w.finishEntry()
+
+	data, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if r.manifestPath != "" {
+		_, err := r.tree.GetEntry(r.manifestPath)
+		if err == nil {
+			return fmt.Errorf("MANIFEST %s: %v", r.manifestPath, os.ErrExist)
+		}
+
+		err = w.WriteHeader(w.formatHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     r.manifestPath,
+			Mode:     int64(0644 | c_ISREG),
+			Uname:    "root",
+			Gname:    "root",
+			Size:     int64(len(data)),
+			ModTime:  w.modTime,
+		}))
+		if err != nil {
+			return err
+		}
+
+		_, err = bytes.NewReader(data).WriteTo(w.Writer)
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.cfg.manifest != nil {
+		_, err := r.cfg.manifest.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestBuild_manifest(t *testing.T) {
+	tarfile := `
+COPY a-dir data
+TESTSYMLINK a.txt data/link
+CHOWN 1000:users data/a.txt
+MANIFEST meta/manifest.json
+`
+
+	var archive, out bytes.Buffer
+	err := Build(&archive, "testdata", writeTarfile(t, tarfile), WithManifest(&out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		last     string
+		embedded []byte
+	)
+	r := tar.NewReader(&archive)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		last = h.Name
+		embedded, _ = ioutil.ReadAll(r)
+	}
+
+	if last != "meta/manifest.json" {
+		t.Fatalf("expected the manifest to be the last entry but got %q", last)
+	}
+	if !bytes.Equal(embedded, out.Bytes()) {
+		t.Errorf("expected the embedded manifest to match the written one")
+	}
+
+	var m Manifest
+	err = json.Unmarshal(out.Bytes(), &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ManifestEntry{
+		{Path: "data", Type: "dir", Mode: m.Entries[0].Mode, Owner: "root:root"},
+		{Path: "data/a.txt", Type: "file", Mode: m.Entries[1].Mode, Owner: "1000:users",
+			SHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{Path: "data/b.txt", Type: "file", Mode: m.Entries[2].Mode, Owner: "root:root",
+			SHA256: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{Path: "data/link", Type: "symlink", Mode: "0777", Owner: "root:root", Target: "a.txt"},
+		{Path: "meta", Type: "dir", Mode: "0755", Owner: "root:root"},
+	}
+	if !reflect.DeepEqual(m.Entries, expected) {
+		t.Errorf("expected %+v but got %+v", expected, m.Entries)
+	}
+}
+
+func TestBuild_manifestCpio(t *testing.T) {
+	err := Build(ioutil.Discard, "testdata", "testdata/Tarfile", WithOutput(OutputCPIO), WithManifest(ioutil.Discard))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestBuild_manifestUnsupported(t *testing.T) {
+	conf := writeTarfile(t, "COPY a-dir data\nMANIFEST meta/manifest.json\n")
+
+	_, err := BuildLayers("testdata", conf)
+	if err != errManifest {
+		t.Errorf("layers: expected %v but got %v", errManifest, err)
+	}
+
+	err = BuildOCILayout(t.TempDir(), "testdata", conf, ImageConfig{})
+	if err != errManifest {
+		t.Errorf("OCI: expected %v but got %v", errManifest, err)
+	}
+
+	err = VerifyReproducible("testdata", "testdata/Tarfile", WithManifest(ioutil.Discard))
+	if err == nil {
+		t.Error("verify: expected an error")
+	}
+}
@@ -0,0 +1,305 @@
+package tarbuild
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// pathMatcher matches slash separated paths against a list of glob patterns.
+//
+// The patterns follow the .tarignore (gitignore) rules:
+//   * `*`, `?` and `[...]` never match a `/`.
+//   * A leading `**/` matches in all directories, a trailing `/**` matches
+//     everything inside a directory and `/**/` matches zero or more
+//     directories.
+//   * `{a,b}` matches either alternative; braces may be nested.
+//   * A pattern with a trailing `/` only matches directories.
+//   * A pattern prefixed with `!` excludes the paths it matches again. The
+//     last matching pattern wins.
+//   * A `\` escapes the next character.
+//
+// In ignore files a pattern without a `/` (other than a trailing one) matches
+// at any depth, like in .gitignore. The patterns of commands name paths in the
+// tree, so they are anchored at the root (a leading `/` is optional) and a
+// list starting with an exclusion applies it to `*`: `!*.md` matches the
+// entries in the root which don't end in .md.
+type pathMatcher struct {
+	rules []matchRule
+}
+
+type matchRule struct {
+	negate  bool
+	dirOnly bool
+	re      *regexp.Regexp
+}
+
+// compileMatcher compiles the patterns of a command.
+func compileMatcher(patterns []string) (*pathMatcher, error) {
+	if len(patterns) > 0 && strings.HasPrefix(patterns[0], "!") {
+		patterns = append([]string{"*"}, patterns...)
+	}
+	return compilePatterns(patterns, false)
+}
+
+// compileIgnore compiles the lines of an ignore file. Blank lines and
+// comments are skipped.
+func compileIgnore(lines []string) (*pathMatcher, error) {
+	var patterns []string
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	return compilePatterns(patterns, true)
+}
+
+func compilePatterns(patterns []string, unanchored bool) (*pathMatcher, error) {
+	m := &pathMatcher{}
+
+	for _, p := range patterns {
+		var rule matchRule
+
+		if strings.HasPrefix(p, "!") {
+			rule.negate = true
+			p = p[1:]
+		}
+		if strings.HasSuffix(p, "/") && !strings.HasSuffix(p, "\\/") {
+			rule.dirOnly = true
+			p = strings.TrimRight(p, "/")
+		}
+		if unanchored && !strings.Contains(p, "/") {
+			p = "**/" + p
+		}
+		p = strings.TrimPrefix(p, "./")
+		p = strings.TrimLeft(p, "/")
+
+		expr, err := globToRegexp(p)
+		if err != nil {
+			return nil, err
+		}
+
+		rule.re, err = regexp.Compile(expr)
+		if err != nil {
+			return nil, filepath.ErrBadPattern
+		}
+
+		m.rules = append(m.rules, rule)
+	}
+
+	return m, nil
+}
+
+// Match reports whether name matches the patterns of m.
+func (m *pathMatcher) Match(name string, isDir bool) bool {
+	matched := false
+
+	for _, rule := range m.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(name) {
+			matched = !rule.negate
+		}
+	}
+
+	return matched
+}
+
+// MatchTree reports whether name or one of its parent directories match the
+// patterns of m, the way .tarignore excludes whole directories.
+func (m *pathMatcher) MatchTree(name string, isDir bool) bool {
+	for idx := strings.IndexByte(name, '/'); idx >= 0; {
+		if m.Match(name[:idx], true) {
+			return true
+		}
+		next := strings.IndexByte(name[idx+1:], '/')
+		if next < 0 {
+			break
+		}
+		idx += next + 1
+	}
+	return m.Match(name, isDir)
+}
+
+func globToRegexp(pattern string) (string, error) {
+	var (
+		buf    strings.Builder
+		braces int
+	)
+
+	buf.WriteByte('^')
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		atSegmentStart := i == 0 || pattern[i-1] == '/'
+
+		switch c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**") && atSegmentStart {
+				rest := pattern[i+2:]
+				if rest == "" {
+					buf.WriteString(`.*`)
+					i++
+					continue
+				}
+				if rest[0] == '/' {
+					buf.WriteString(`(?:.*/)?`)
+					i += 2
+					continue
+				}
+			}
+			for i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+			}
+			buf.WriteString(`[^/]*`)
+
+		case '?':
+			buf.WriteString(`[^/]`)
+
+		case '[':
+			end := i + 1
+			if end < len(pattern) && (pattern[end] == '!' || pattern[end] == '^') {
+				end++
+			}
+			if end < len(pattern) && pattern[end] == ']' {
+				end++
+			}
+			for end < len(pattern) && pattern[end] != ']' {
+				if pattern[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(pattern) {
+				return "", filepath.ErrBadPattern
+			}
+
+			buf.WriteString(globClass(pattern[i+1 : end]))
+			i = end
+
+		case '{':
+			braces++
+			buf.WriteString(`(?:`)
+
+		case ',':
+			if braces > 0 {
+				buf.WriteString(`|`)
+			} else {
+				buf.WriteString(regexp.QuoteMeta(","))
+			}
+
+		case '}':
+			if braces == 0 {
+				return "", filepath.ErrBadPattern
+			}
+			braces--
+			buf.WriteString(`)`)
+
+		case '\\':
+			i++
+			if i >= len(pattern) {
+				return "", filepath.ErrBadPattern
+			}
+			buf.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+
+		default:
+			buf.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+
+	if braces > 0 {
+		return "", filepath.ErrBadPattern
+	}
+
+	buf.WriteByte('$')
+	return buf.String(), nil
+}
+
+// globClass converts the contents of a [...] class to a regular expression
+// class which never matches a `/`, even in a range like [+-0].
+func globClass(class string) string {
+	var (
+		buf     strings.Builder
+		negated bool
+		empty   = true
+	)
+
+	if strings.HasPrefix(class, "!") || strings.HasPrefix(class, "^") {
+		negated = true
+		class = class[1:]
+	}
+
+	writeRange := func(lo, hi rune) {
+		if lo == hi {
+			fmt.Fprintf(&buf, `\x{%x}`, lo)
+		} else {
+			fmt.Fprintf(&buf, `\x{%x}-\x{%x}`, lo, hi)
+		}
+		empty = false
+	}
+
+	chars := []rune(class)
+	next := func(i int) (rune, int) {
+		if chars[i] == '\\' && i+1 < len(chars) {
+			i++
+		}
+		return chars[i], i + 1
+	}
+
+	for i := 0; i < len(chars); {
+		var lo, hi rune
+		lo, i = next(i)
+		hi = lo
+		if i+1 < len(chars) && chars[i] == '-' {
+			hi, i = next(i + 1)
+		}
+
+		switch {
+		case hi < lo:
+		case lo <= '/' && '/' <= hi:
+			// Leave the slash out of the range.
+			if lo < '/' {
+				writeRange(lo, '/'-1)
+			}
+			if '/' < hi {
+				writeRange('/'+1, hi)
+			}
+		default:
+			writeRange(lo, hi)
+		}
+	}
+
+	if negated {
+		return `[^/` + buf.String() + `]`
+	}
+	if empty {
+		// A class of slashes matches nothing.
+		return `[^\x00-\x{10FFFF}]`
+	}
+	return `[` + buf.String() + `]`
+}
+
+// matchEntries returns the deep entries of d that match patterns.
+func (d *Dir) matchEntries(patterns []string) ([]string, error) {
+	m, err := compileMatcher(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, n := range d.DeepEntries {
+		e, err := d.GetEntry(n)
+		if err != nil {
+			return nil, err
+		}
+		if m.Match(n, e.isDir()) {
+			names = append(names, n)
+		}
+	}
+
+	return names, nil
+}
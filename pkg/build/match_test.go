@@ -0,0 +1,107 @@
+package tarbuild
+
+import "testing"
+
+func Test_pathMatcher(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		name     string
+		isDir    bool
+		expected bool
+	}{
+		{[]string{"*.go"}, "main.go", false, true},
+		{[]string{"*.go"}, "cmd/main.go", false, false},
+		{[]string{"/*.go"}, "main.go", false, true},
+		{[]string{"src/**/*.go"}, "src/main.go", false, true},
+		{[]string{"src/**/*.go"}, "src/a/b/main.go", false, true},
+		{[]string{"src/**/*.go"}, "lib/main.go", false, false},
+		{[]string{"**/*.go"}, "a/b/main.go", false, true},
+		{[]string{"src/**"}, "src/a/b", false, true},
+		{[]string{"src/**"}, "src", true, false},
+		{[]string{"{bin,lib}/*"}, "bin/tool", false, true},
+		{[]string{"{bin,lib}/*"}, "lib/libc.so", false, true},
+		{[]string{"{bin,lib}/*"}, "sbin/tool", false, false},
+		{[]string{"*.{tar{,.gz},zip}"}, "a.tar.gz", false, true},
+		{[]string{"*.{tar{,.gz},zip}"}, "a.tar", false, true},
+		{[]string{"*.{tar{,.gz},zip}"}, "a.gz", false, false},
+		{[]string{"a?c"}, "abc", false, true},
+		{[]string{"a?c"}, "a/c", false, false},
+		{[]string{"[a-c]x"}, "bx", false, true},
+		{[]string{"[!a-c]x"}, "dx", false, true},
+		{[]string{"[!a-c]x"}, "bx", false, false},
+		{[]string{"build/"}, "build", true, true},
+		{[]string{"build/"}, "build", false, false},
+		{[]string{"**/*.go", "!**/*_test.go"}, "pkg/a.go", false, true},
+		{[]string{"**/*.go", "!**/*_test.go"}, "pkg/a_test.go", false, false},
+		{[]string{"**/*.go", "!**/*_test.go", "pkg/x_test.go"}, "pkg/x_test.go", false, true},
+		{[]string{"!*.md"}, "main.go", false, true},
+		{[]string{"!*.md"}, "README.md", false, false},
+		{[]string{"!*.md"}, "docs/a.txt", false, false},
+		{[]string{"a[+-0]b"}, "a.b", false, true},
+		{[]string{"a[+-0]b"}, "a/b", false, false},
+		{[]string{"a[/]b"}, "a/b", false, false},
+		{[]string{"a[!x]b"}, "a/b", false, false},
+		{[]string{`\*.txt`}, "*.txt", false, true},
+		{[]string{`\*.txt`}, "a.txt", false, false},
+		{nil, "a", false, false},
+	}
+
+	for _, test := range tests {
+		m, err := compileMatcher(test.patterns)
+		if err != nil {
+			t.Fatalf("%q: %v", test.patterns, err)
+		}
+
+		actual := m.Match(test.name, test.isDir)
+		if actual != test.expected {
+			t.Errorf("%q.Match(%q, %v): expected %v but got %v", test.patterns, test.name, test.isDir, test.expected, actual)
+		}
+	}
+}
+
+func Test_compileIgnore(t *testing.T) {
+	lines := []string{
+		"# logs",
+		"*.log",
+		"!keep.log",
+		"/build",
+		"tmp/",
+		"",
+	}
+
+	m, err := compileIgnore(lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		isDir    bool
+		expected bool
+	}{
+		{"a.log", false, true},
+		{"a/b/c.log", false, true},
+		{"a/keep.log", false, false},
+		{"build", true, true},
+		{"a/build", true, false},
+		{"a/tmp", true, true},
+		{"a/tmp", false, false},
+		{"# logs", false, false},
+	}
+
+	for _, test := range tests {
+		actual := m.Match(test.name, test.isDir)
+		if actual != test.expected {
+			t.Errorf("Match(%q, %v): expected %v but got %v", test.name, test.isDir, test.expected, actual)
+		}
+	}
+}
+
+func Test_pathMatcher_badPattern(t *testing.T) {
+	for _, p := range []string{"{a,b", "a}", "[abc", `a\`} {
+		_, err := compileMatcher([]string{p})
+		if err == nil {
+			t.Errorf("%q: expected an error", p)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package tarbuild
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type mtimeMode int
+
+const (
+	mtimeFixed mtimeMode = iota
+	mtimeSourceDateEpoch
+	mtimePreserve
+)
+
+// WithModTime sets the modification time of all entries to t. By default
+// entries are written with a fixed time in 1988.
+func WithModTime(t time.Time) Option {
+	return func(c *buildConfig) {
+		c.mtimeMode = mtimeFixed
+		c.mtime = t
+	}
+}
+
+// WithSourceDateEpoch sets the modification time of all entries to the time
+// in the SOURCE_DATE_EPOCH environment variable.
+// See https://reproducible-builds.org/specs/source-date-epoch/
+func WithSourceDateEpoch() Option {
+	return func(c *buildConfig) {
+		c.mtimeMode = mtimeSourceDateEpoch
+	}
+}
+
+// WithPreservedModTime keeps the modification times of the files and
+// directories in the build context. Entries which don't come from the
+// context get the fixed time.
+func WithPreservedModTime() Option {
+	return func(c *buildConfig) {
+		c.mtimeMode = mtimePreserve
+	}
+}
+
+// ParseModTime returns the option for an --mtime value: "fixed",
+// "source-date-epoch", "preserve" or a time (see TOUCH for the formats).
+func ParseModTime(value string) (Option, error) {
+	switch value {
+	case "fixed":
+		return WithModTime(ftime), nil
+	case "source-date-epoch":
+		return WithSourceDateEpoch(), nil
+	case "preserve":
+		return WithPreservedModTime(), nil
+	}
+
+	t, err := parseTime(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mtime %q: expected fixed, source-date-epoch, preserve or a time", value)
+	}
+	return WithModTime(t), nil
+}
+
+// modTime returns the time used for entries which were not touched and
+// whether the times from the context should be preserved.
+func (c *buildConfig) modTime() (time.Time, bool, error) {
+	switch c.mtimeMode {
+	case mtimeSourceDateEpoch:
+		v := os.Getenv("SOURCE_DATE_EPOCH")
+		if v == "" {
+			return time.Time{}, false, fmt.Errorf("SOURCE_DATE_EPOCH is not set")
+		}
+		sec, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q", v)
+		}
+		return time.Unix(sec, 0).UTC(), false, nil
+
+	case mtimePreserve:
+		return ftime, true, nil
+
+	default:
+		if c.mtime.IsZero() {
+			return ftime, false, nil
+		}
+		return c.mtime, false, nil
+	}
+}
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// parseTime parses an RFC 3339 time, a date and time without a zone (UTC), a
+// date or a unix timestamp prefixed with @.
+func parseTime(s string) (time.Time, error) {
+	if strings.HasPrefix(s, "@") {
+		sec, err := strconv.ParseInt(s[1:], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %q", s)
+		}
+		return time.Unix(sec, 0).UTC(), nil
+	}
+
+	for _, layout := range timeLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid time %q", s)
+}
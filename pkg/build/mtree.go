@@ -0,0 +1,427 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// WithMtree writes an mtree specification of the archive made by Build to
+// dst.
+func WithMtree(dst io.Writer) Option {
+	return func(c *buildConfig) {
+		c.mtree = dst
+	}
+}
+
+// mtreeEntry is an entry of an mtree specification: its keywords by name.
+type mtreeEntry struct {
+	path     string
+	keywords map[string]string
+}
+
+// mtreeKeywords is the order in which keywords are written.
+var mtreeKeywords = []string{"type", "mode", "uid", "uname", "gid", "gname", "size", "time", "link", "device", "sha256digest"}
+
+var mtreeTypes = map[byte]string{
+	tar.TypeReg:     "file",
+	tar.TypeDir:     "dir",
+	tar.TypeSymlink: "link",
+	tar.TypeChar:    "char",
+	tar.TypeBlock:   "block",
+	tar.TypeFifo:    "fifo",
+}
+
+// readMtreeEntries describes the entries of a tar archive.
+func readMtreeEntries(r io.Reader) ([]mtreeEntry, error) {
+	var entries []mtreeEntry
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		kw := map[string]string{
+			"type": mtreeTypes[h.Typeflag],
+			"mode": fmt.Sprintf("%04o", h.Mode&07777),
+			"time": fmt.Sprintf("%d.%09d", h.ModTime.Unix(), h.ModTime.Nanosecond()),
+		}
+		mtreeOwner(kw, "uid", "uname", h.Uid, h.Uname)
+		mtreeOwner(kw, "gid", "gname", h.Gid, h.Gname)
+
+		switch h.Typeflag {
+		case tar.TypeReg:
+			kw["size"] = strconv.FormatInt(h.Size, 10)
+
+			digests := map[string]hash.Hash{
+				"md5digest":    md5.New(),
+				"sha1digest":   sha1.New(),
+				"sha256digest": sha256.New(),
+			}
+			w := io.MultiWriter(digests["md5digest"], digests["sha1digest"], digests["sha256digest"])
+			_, err := io.Copy(w, tr)
+			if err != nil {
+				return nil, err
+			}
+			for k, d := range digests {
+				kw[k] = hex.EncodeToString(d.Sum(nil))
+			}
+		case tar.TypeSymlink:
+			kw["link"] = mtreeEncode(h.Linkname)
+		case tar.TypeChar, tar.TypeBlock:
+			kw["device"] = fmt.Sprintf("native,%d,%d", h.Devmajor, h.Devminor)
+		}
+
+		entries = append(entries, mtreeEntry{path: mtreePath(h.Name), keywords: kw})
+	}
+}
+
+// mtreeOwner sets the keywords of a user or group. Numeric names are used
+// as ids.
+func mtreeOwner(kw map[string]string, idKey, nameKey string, id int, name string) {
+	if _, err := strconv.Atoi(name); err == nil {
+		kw[idKey] = name
+		return
+	}
+
+	kw[idKey] = strconv.Itoa(id)
+	if name != "" {
+		kw[nameKey] = name
+	}
+}
+
+// mtreePath returns the ./ prefixed path of an archive entry.
+func mtreePath(name string) string {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "."
+	}
+	return "." + name
+}
+
+// writeMtree writes entries in the full path format of mtree(5).
+func writeMtree(dst io.Writer, entries []mtreeEntry) error {
+	var buf bytes.Buffer
+
+	buf.WriteString("#mtree v2.0\n")
+	for _, e := range entries {
+		buf.WriteString(mtreeEncode(e.path))
+		for _, k := range mtreeKeywords {
+			if v, found := e.keywords[k]; found {
+				buf.WriteString(" " + k + "=" + v)
+			}
+		}
+		buf.WriteByte('\n')
+	}
+
+	_, err := buf.WriteTo(dst)
+	return err
+}
+
+// mtreeEncode escapes whitespace, backslashes, # and non printable bytes as
+// \ooo like vis(3).
+func mtreeEncode(s string) string {
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || c == '\\' || c == '#' || c == '=' {
+			fmt.Fprintf(&buf, "\\%03o", c)
+		} else {
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+func mtreeDecode(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+
+	var buf strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 == len(s) {
+			buf.WriteByte(c)
+			continue
+		}
+
+		if i+3 < len(s) && isOctal(s[i+1]) && isOctal(s[i+2]) && isOctal(s[i+3]) {
+			n, _ := strconv.ParseUint(s[i+1:i+4], 8, 8)
+			buf.WriteByte(byte(n))
+			i += 3
+			continue
+		}
+
+		i++
+		switch s[i] {
+		case 's':
+			buf.WriteByte(' ')
+		case 't':
+			buf.WriteByte('\t')
+		case 'n':
+			buf.WriteByte('\n')
+		default:
+			buf.WriteByte(s[i])
+		}
+	}
+	return buf.String()
+}
+
+func isOctal(c byte) bool {
+	return '0' <= c && c <= '7'
+}
+
+// parseMtree reads a specification in the full path or the relative format,
+// with /set and /unset defaults.
+func parseMtree(r io.Reader) ([]mtreeEntry, error) {
+	var (
+		entries  []mtreeEntry
+		defaults = map[string]string{}
+		cwd      = "."
+		lineno   int
+		pending  string
+	)
+
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		lineno++
+		line := pending + sc.Text()
+		pending = ""
+
+		if strings.HasSuffix(line, "\\") && !strings.HasSuffix(line, "\\\\") {
+			pending = strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch fields[0] {
+		case "/set":
+			for k, v := range parseMtreeKeywords(fields[1:]) {
+				defaults[k] = v
+			}
+			continue
+		case "/unset":
+			for _, k := range fields[1:] {
+				if k == "all" {
+					defaults = map[string]string{}
+				}
+				delete(defaults, k)
+			}
+			continue
+		case "..":
+			if cwd == "." {
+				return nil, fmt.Errorf("mtree line %d: .. above the root", lineno)
+			}
+			cwd = path.Dir(cwd)
+			continue
+		}
+
+		kw := map[string]string{}
+		for k, v := range defaults {
+			kw[k] = v
+		}
+		for k, v := range parseMtreeKeywords(fields[1:]) {
+			kw[k] = v
+		}
+
+		name := mtreeDecode(fields[0])
+		if strings.Contains(name, "/") {
+			name = mtreePath(name)
+		} else {
+			name = mtreePath(path.Join(cwd, name))
+			if kw["type"] == "dir" {
+				cwd = name
+			}
+		}
+
+		entries = append(entries, mtreeEntry{path: name, keywords: kw})
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// parseMtreeKeywords parses key=value fields. Digests have several names,
+// they are stored under the names used by writeMtree.
+func parseMtreeKeywords(fields []string) map[string]string {
+	kw := map[string]string{}
+	for _, f := range fields {
+		idx := strings.IndexByte(f, '=')
+		if idx < 0 {
+			kw[f] = ""
+			continue
+		}
+
+		k, v := f[:idx], f[idx+1:]
+		switch k {
+		case "md5":
+			k = "md5digest"
+		case "sha1":
+			k = "sha1digest"
+		case "sha256":
+			k = "sha256digest"
+		}
+		kw[k] = v
+	}
+	return kw
+}
+
+// MtreeDiff is a difference between an mtree specification and an archive.
+type MtreeDiff struct {
+	Path string
+
+	// Keyword is empty when the entry is missing from the archive (Expected
+	// is set) or not in the specification (Actual is set).
+	Keyword  string
+	Expected string
+	Actual   string
+}
+
+func (d MtreeDiff) String() string {
+	switch {
+	case d.Keyword != "":
+		return fmt.Sprintf("%s: %s expected %s found %s", d.Path, d.Keyword, d.Expected, d.Actual)
+	case d.Expected != "":
+		return fmt.Sprintf("%s: missing", d.Path)
+	default:
+		return fmt.Sprintf("%s: extra", d.Path)
+	}
+}
+
+// VerifyMtree checks a tar archive, which may be gzip compressed, against an
+// mtree specification. Only the keywords of the specification are checked;
+// the root directory may be left out of the archive.
+func VerifyMtree(spec, archive io.Reader) ([]MtreeDiff, error) {
+	expected, err := parseMtree(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := maybeGunzip(bufio.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	entries, err := readMtreeEntries(r)
+	if err != nil {
+		return nil, err
+	}
+
+	actual := map[string]map[string]string{}
+	for _, e := range entries {
+		actual[e.path] = e.keywords
+	}
+
+	var (
+		diffs []MtreeDiff
+		seen  = map[string]bool{}
+	)
+
+	for _, e := range expected {
+		seen[e.path] = true
+
+		kw, found := actual[e.path]
+		if !found {
+			if e.path != "." {
+				diffs = append(diffs, MtreeDiff{Path: e.path, Expected: "present"})
+			}
+			continue
+		}
+
+		var keys []string
+		for k := range e.keywords {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			// Keywords like nlink or flags can't be checked.
+			if !mtreeCheckable[k] {
+				continue
+			}
+
+			want, got := e.keywords[k], kw[k]
+			if !mtreeEqual(k, want, got) {
+				diffs = append(diffs, MtreeDiff{Path: e.path, Keyword: k, Expected: want, Actual: got})
+			}
+		}
+	}
+
+	for _, e := range entries {
+		if !seen[e.path] {
+			diffs = append(diffs, MtreeDiff{Path: e.path, Actual: "present"})
+		}
+	}
+
+	return diffs, nil
+}
+
+var mtreeCheckable = map[string]bool{
+	"type": true, "mode": true, "uid": true, "uname": true, "gid": true, "gname": true,
+	"size": true, "time": true, "link": true, "device": true,
+	"md5digest": true, "sha1digest": true, "sha256digest": true,
+}
+
+func mtreeEqual(keyword, want, got string) bool {
+	switch keyword {
+	case "mode":
+		a, errA := strconv.ParseUint(want, 8, 32)
+		b, errB := strconv.ParseUint(got, 8, 32)
+		return errA == nil && errB == nil && a == b
+	case "time":
+		return mtreeTime(want) == mtreeTime(got)
+	case "link":
+		return mtreeDecode(want) == mtreeDecode(got)
+	case "device":
+		return mtreeDevice(want) == mtreeDevice(got)
+	case "uid", "gid", "size":
+		a, errA := strconv.ParseInt(want, 10, 64)
+		b, errB := strconv.ParseInt(got, 10, 64)
+		return errA == nil && errB == nil && a == b
+	default:
+		return strings.EqualFold(want, got)
+	}
+}
+
+// mtreeTime normalizes a seconds.nanoseconds time.
+func mtreeTime(s string) string {
+	sec, nsec := s, "0"
+	if idx := strings.IndexByte(s, '.'); idx >= 0 {
+		sec, nsec = s[:idx], s[idx+1:]
+	}
+	a, _ := strconv.ParseInt(sec, 10, 64)
+	b, _ := strconv.ParseInt(nsec, 10, 64)
+	return fmt.Sprintf("%d.%09d", a, b)
+}
+
+// mtreeDevice drops the format of a device keyword.
+func mtreeDevice(s string) string {
+	parts := strings.Split(s, ",")
+	if len(parts) == 3 {
+		return parts[1] + "," + parts[2]
+	}
+	return s
+}
@@ -0,0 +1,92 @@
+package tarbuild
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVerifyMtree(t *testing.T) {
+	tarfile := `
+COPY a-dir data
+CHMOD 0644 data/a.txt
+TESTSYMLINK a.txt data/link
+MKNOD console c 5 1
+`
+
+	var archive, spec bytes.Buffer
+	err := Build(&archive, "testdata", writeTarfile(t, tarfile), WithMtree(&spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err := VerifyMtree(bytes.NewReader(spec.Bytes()), bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) != 0 {
+		t.Fatalf("expected no differences but got %v", diffs)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(spec.String(), "\n") {
+		switch {
+		case strings.HasPrefix(line, "./data/b.txt "):
+			// Leave b.txt out, it is extra.
+		case strings.HasPrefix(line, "./data/a.txt "):
+			lines = append(lines, strings.Replace(line, " mode=", " mode=0600 x=", 1))
+		default:
+			lines = append(lines, line)
+		}
+	}
+	lines = append(lines, "./data/c.txt type=file")
+
+	diffs, err = VerifyMtree(strings.NewReader(strings.Join(lines, "\n")), bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, d := range diffs {
+		got = append(got, d.String())
+	}
+	expected := []string{
+		"./data/a.txt: mode expected 0600 found 0644",
+		"./data/c.txt: missing",
+		"./data/b.txt: extra",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %q but got %q", expected, got)
+	}
+}
+
+func TestParseMtree(t *testing.T) {
+	spec := `#mtree
+/set type=file uid=0 gid=0 mode=0644
+.               type=dir mode=0755
+    etc         type=dir mode=0755
+        my\040file  size=3 \
+                    sha256=abc
+        ..
+    bin/sh      type=link link=busybox
+/unset all
+    usr         type=dir
+`
+
+	entries, err := parseMtree(strings.NewReader(spec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []mtreeEntry{
+		{".", map[string]string{"type": "dir", "uid": "0", "gid": "0", "mode": "0755"}},
+		{"./etc", map[string]string{"type": "dir", "uid": "0", "gid": "0", "mode": "0755"}},
+		{"./etc/my file", map[string]string{"type": "file", "uid": "0", "gid": "0", "mode": "0644", "size": "3", "sha256digest": "abc"}},
+		{"./bin/sh", map[string]string{"type": "link", "uid": "0", "gid": "0", "mode": "0644", "link": "busybox"}},
+		{"./usr", map[string]string{"type": "dir"}},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %v but got %v", expected, entries)
+	}
+}
@@ -0,0 +1,175 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// NodeType is the kind of a Node.
+type NodeType byte
+
+const (
+	CharDevice  NodeType = tar.TypeChar
+	BlockDevice NodeType = tar.TypeBlock
+	FIFO        NodeType = tar.TypeFifo
+)
+
+// Node is a character device, a block device or a FIFO. Nodes only exist in
+// the archive, nothing is created on the host.
+type Node struct {
+	name    string
+	Kind    NodeType
+	Major   int64
+	Minor   int64
+	Perm    os.FileMode
+	User    string
+	Group   string
+	ModTime time.Time
+	Touched bool
+	Xattrs  map[string]string
+}
+
+// AddNode adds a new device node or FIFO to d. For FIFOs major and minor are
+// ignored.
+func (d *Dir) AddNode(name string, typ NodeType, major, minor int64) (*Node, error) {
+	name = path.Join(".", path.Join("/", name))
+
+	dirName, fileName := path.Split(name)
+	dirName = path.Join(".", path.Join("/", dirName))
+	parent, err := d.MkdirAll(dirName)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = parent.GetEntry(fileName)
+	if err == nil {
+		err = os.ErrExist
+	}
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	node := &Node{
+		name:  fileName,
+		Kind:  typ,
+		Perm:  0644,
+		User:  "root",
+		Group: "root",
+	}
+	if typ != FIFO {
+		node.Major = major
+		node.Minor = minor
+	}
+
+	d.insert(name, parent, node)
+	return node, nil
+}
+
+func (n *Node) isDir() bool {
+	return false
+}
+
+func (n *Node) Name() string {
+	return n.name
+}
+
+func (n *Node) clone(name string) Entry {
+	dst := &Node{}
+	*dst = *n
+	dst.name = name
+	return dst
+}
+
+func (n *Node) Mode() os.FileMode {
+	return n.Perm | n.Type()
+}
+
+func (n *Node) Type() os.FileMode {
+	switch n.Kind {
+	case CharDevice:
+		return os.ModeDevice | os.ModeCharDevice
+	case BlockDevice:
+		return os.ModeDevice
+	default:
+		return os.ModeNamedPipe
+	}
+}
+
+func (n *Node) Owner() (user, group string) {
+	return n.User, n.Group
+}
+
+func (n *Node) Open() (io.ReadCloser, error) {
+	return nil, errNotRegular(n.name)
+}
+
+func (n *Node) bakeDeepEntries() []string {
+	return nil
+}
+
+func (n *Node) chown(user, group string, recursive bool) {
+	if user != "" {
+		n.User = user
+	}
+	if group != "" {
+		n.Group = group
+	}
+}
+
+func (n *Node) chmod(mask, mode uint32, recursive bool) {
+	n.Perm = os.FileMode((mask & mode) | (uint32(n.Perm) & (0xFFFFFFFF ^ mask)))
+}
+
+func (n *Node) touch(t time.Time) {
+	n.ModTime = t
+	n.Touched = true
+}
+
+func (n *Node) setXattr(name, value string) {
+	n.Xattrs = withXattr(n.Xattrs, name, value)
+}
+
+func (n *Node) tarHeader(path string, w *tarWriter) (*tar.Header, error) {
+	mtime := w.entryModTime(n.ModTime, n.Touched)
+
+	var typeBits os.FileMode
+	switch n.Kind {
+	case CharDevice:
+		typeBits = c_ISCHR
+	case BlockDevice:
+		typeBits = c_ISBLK
+	case FIFO:
+		typeBits = c_ISFIFO
+	}
+
+	return &tar.Header{
+		Typeflag:   byte(n.Kind),
+		Mode:       int64(n.Perm | typeBits),
+		Name:       path,
+		Uname:      n.User,
+		Gname:      n.Group,
+		Uid:        ownerID(n.User),
+		Gid:        ownerID(n.Group),
+		Devmajor:   n.Major,
+		Devminor:   n.Minor,
+		AccessTime: mtime,
+		ChangeTime: mtime,
+		ModTime:    mtime,
+		PAXRecords: xattrRecords(n.Xattrs),
+	}, nil
+}
+
+func (n *Node) writeToTar(path string, w *tarWriter) error {
+	h, err := n.tarHeader(path, w)
+	if err != nil {
+		return err
+	}
+
+	return w.writeHeader(h)
+}
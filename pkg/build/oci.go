@@ -0,0 +1,194 @@
+package tarbuild
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// ImageConfig describes the container image around the built layer.
+type ImageConfig struct {
+	// OS and Architecture of the image. They default to those of the base
+	// image, or linux and amd64.
+	OS           string
+	Architecture string
+
+	// Base is the path of an image tarball in the docker save format. When
+	// it is set the built layer is added on top of its layers and the
+	// settings below are merged into its config.
+	Base string
+
+	Entrypoint []string
+	Env        []string
+	WorkingDir string
+	User       string
+	Labels     map[string]string
+}
+
+const (
+	mediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIConfig   = "application/vnd.oci.image.config.v1+json"
+	mediaTypeOCILayer    = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// BuildOCILayout builds the Tarfile and writes it as the single layer of an
+// image in the OCI image layout at dir. The output only depends on the
+// inputs, so identical builds produce identical digests.
+func BuildOCILayout(dir, wd, conf string, img ImageConfig, opts ...Option) error {
+	return BuildOCILayoutContext(context.Background(), dir, wd, conf, img, opts...)
+}
+
+// BuildOCILayoutContext is like BuildOCILayout but stops with the error of ctx
+// when ctx is done, like BuildContext.
+func BuildOCILayoutContext(ctx context.Context, dir, wd, conf string, img ImageConfig, opts ...Option) error {
+	r, err := build(ctx, wd, conf, opts)
+	if err != nil {
+		return err
+	}
+
+	layers, err := r.layers()
+	if err != nil {
+		return err
+	}
+
+	im, err := newImage(r.mtime, img, layers)
+	if err != nil {
+		return err
+	}
+
+	return writeOCILayout(dir, im)
+}
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+// blob is a compressed layer, a config or a manifest.
+type blob struct {
+	data   []byte
+	digest string
+}
+
+func newBlob(data []byte) blob {
+	return blob{data: data, digest: sha256Digest(data)}
+}
+
+func (b blob) descriptor(mediaType string) ociDescriptor {
+	return ociDescriptor{
+		MediaType: mediaType,
+		Digest:    b.digest,
+		Size:      int64(len(b.data)),
+	}
+}
+
+func sha256Digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// gzipData compresses data. The gzip header has no name and no time so the
+// result only depends on data.
+func gzipData(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = w.Write(data)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeOCILayout(dir string, im *image) error {
+	var blobs []blob
+
+	manifest := ociManifest{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIManifest,
+		Layers:        []ociDescriptor{},
+	}
+
+	for _, layer := range im.layers {
+		data, err := gzipData(layer)
+		if err != nil {
+			return err
+		}
+
+		b := newBlob(data)
+		blobs = append(blobs, b)
+		manifest.Layers = append(manifest.Layers, b.descriptor(mediaTypeOCILayer))
+	}
+
+	configBlob := newBlob(im.config)
+	blobs = append(blobs, configBlob)
+	manifest.Config = configBlob.descriptor(mediaTypeOCIConfig)
+
+	manifestData, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	manifestBlob := newBlob(manifestData)
+	blobs = append(blobs, manifestBlob)
+
+	index := ociIndex{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.index.v1+json",
+		Manifests:     []ociDescriptor{manifestBlob.descriptor(mediaTypeOCIManifest)},
+	}
+
+	indexData, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+
+	blobDir := filepath.Join(dir, "blobs", "sha256")
+	err = os.MkdirAll(blobDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	for _, b := range blobs {
+		err := ioutil.WriteFile(filepath.Join(blobDir, b.digest[len("sha256:"):]), b.data, 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(dir, "index.json"), indexData, 0644)
+}
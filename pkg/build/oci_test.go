@@ -0,0 +1,93 @@
+package tarbuild
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildOCILayout(t *testing.T) {
+	img := ImageConfig{
+		Entrypoint: []string{"/bin/app"},
+		Env:        []string{"A=1"},
+		Labels:     map[string]string{"b": "2", "a": "1"},
+	}
+
+	var layouts []string
+	for i := 0; i < 2; i++ {
+		dir, err := ioutil.TempDir("", "oci")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		err = BuildOCILayout(dir, "testdata", "testdata/Tarfile", img)
+		if err != nil {
+			t.Fatal(err)
+		}
+		layouts = append(layouts, dir)
+	}
+
+	index := readFile(t, layouts[0], "index.json")
+	if !bytes.Equal(index, readFile(t, layouts[1], "index.json")) {
+		t.Fatal("expected identical builds to produce identical digests")
+	}
+
+	var idx ociIndex
+	mustUnmarshal(t, index, &idx)
+
+	var manifest ociManifest
+	mustUnmarshal(t, readBlob(t, layouts[0], idx.Manifests[0].Digest), &manifest)
+
+	var config struct {
+		OS     string
+		Config struct{ Entrypoint []string }
+		RootFS imageRootFS
+	}
+	mustUnmarshal(t, readBlob(t, layouts[0], manifest.Config.Digest), &config)
+
+	zr, err := gzip.NewReader(bytes.NewReader(readBlob(t, layouts[0], manifest.Layers[0].Digest)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.RootFS.DiffIDs[0] != sha256Digest(layer) {
+		t.Errorf("diff_id %s doesn't match the layer", config.RootFS.DiffIDs[0])
+	}
+	if strings.Join(config.Config.Entrypoint, " ") != "/bin/app" || config.OS != "linux" {
+		t.Errorf("unexpected config %+v", config)
+	}
+}
+
+func readFile(t *testing.T, elem ...string) []byte {
+	t.Helper()
+
+	data, err := ioutil.ReadFile(filepath.Join(elem...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func readBlob(t *testing.T, dir, digest string) []byte {
+	t.Helper()
+	return readFile(t, dir, "blobs", "sha256", strings.TrimPrefix(digest, "sha256:"))
+}
+
+func mustUnmarshal(t *testing.T, data []byte, v interface{}) {
+	t.Helper()
+
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
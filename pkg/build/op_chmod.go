@@ -2,13 +2,16 @@ package tarbuild
 
 import (
 	"fmt"
-	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 )
 
-func applyCHMOD(dst, src *Dir, op tarOp) error {
+func init() {
+	registerOp("CHMOD", requireArgs, applyCHMOD)
+}
+
+func applyCHMOD(dst, src *Dir, op Op) error {
 	var (
 		args      = op.Args
 		paths     []string
@@ -89,23 +92,19 @@ func applyCHMOD(dst, src *Dir, op tarOp) error {
 
 	paths = args
 
-	for _, n := range dst.DeepEntries {
-		for _, p := range paths {
-			m, err := filepath.Match(p, n)
-			if err != nil {
-				return err
-			}
-			if m {
-				e, err := dst.GetEntry(n)
-				if err != nil {
-					return err
-				}
+	names, err := dst.matchEntries(paths)
+	if err != nil {
+		return err
+	}
 
-				e.chmod(mask, mode, recursive)
-			}
+	for _, n := range names {
+		e, err := dst.GetEntry(n)
+		if err != nil {
+			return err
 		}
+
+		e.chmod(mask, mode, recursive)
 	}
 
-	dst.BakeDeepEntries()
 	return nil
 }
@@ -2,11 +2,14 @@ package tarbuild
 
 import (
 	"fmt"
-	"path/filepath"
 	"strings"
 )
 
-func applyCHOWN(dst, src *Dir, op tarOp) error {
+func init() {
+	registerOp("CHOWN", requireArgs, applyCHOWN)
+}
+
+func applyCHOWN(dst, src *Dir, op Op) error {
 	var (
 		args      = op.Args
 		paths     []string
@@ -35,23 +38,19 @@ func applyCHOWN(dst, src *Dir, op tarOp) error {
 
 	paths = args
 
-	for _, n := range dst.DeepEntries {
-		for _, p := range paths {
-			m, err := filepath.Match(p, n)
-			if err != nil {
-				return err
-			}
-			if m {
-				e, err := dst.GetEntry(n)
-				if err != nil {
-					return err
-				}
-
-				e.chown(user, group, recursive)
-			}
+	names, err := dst.matchEntries(paths)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range names {
+		e, err := dst.GetEntry(n)
+		if err != nil {
+			return err
 		}
+
+		e.chown(user, group, recursive)
 	}
 
-	dst.BakeDeepEntries()
 	return nil
 }
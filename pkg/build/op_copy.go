@@ -1,11 +1,32 @@
 package tarbuild
 
 import (
+	"fmt"
+	"os"
 	"path"
-	"path/filepath"
 	"strings"
 )
 
+func init() {
+	registerOp("COPY", validateCOPY, applyCOPY)
+}
+
+// validateCOPY uses the source as the destination when there is only one
+// argument.
+func validateCOPY(op *Op) error {
+	flags := 0
+	for flags < len(op.Args) && strings.HasPrefix(op.Args[flags], "--") {
+		flags++
+	}
+	if len(op.Args) == flags {
+		return fmt.Errorf("invalid command: %q requires arguments", op.Name)
+	}
+	if len(op.Args) == flags+1 {
+		op.Args = append(op.Args, op.Args[flags])
+	}
+	return nil
+}
+
 // COPY obeys the following rules:
 //   * The <src> path must be inside the context of the build; you cannot
 //     COPY ../something /something, because the first step of a docker build is
@@ -24,28 +45,82 @@ import (
 //     regular file and the contents of <src> will be written at <dest>.
 //   * If <dest> doesn’t exist, it is created along with all missing directories
 //     in its path.
-func applyCOPY(dstFS, srcFS *Dir, op tarOp) error {
-	dst := op.Args[len(op.Args)-1]
-	src := op.Args[:len(op.Args)-1]
+//   * Each --exclude=<pattern> removes the matching paths (and everything
+//     below them) from the sources before they are copied. Exclude patterns
+//     are matched against the path in the context, like <src>.
+//   * With --parents <dest> is always a directory and each <src> is written
+//     at <dest>/<src>, keeping the directories of its path in the context.
+//     Missing parent directories take the metadata of their counterparts in
+//     the context and existing directories are merged instead of replaced.
+func applyCOPY(dstFS, srcFS *Dir, op Op) error {
+	const usage = "usage: COPY [--parents] [--exclude=<pattern>]... <src>... <dst>"
+
+	var (
+		args     = op.Args
+		excludes []string
+		parents  bool
+	)
+
+	for len(args) > 0 && strings.HasPrefix(args[0], "--") {
+		switch {
+		case strings.HasPrefix(args[0], "--exclude="):
+			excludes = append(excludes, strings.TrimPrefix(args[0], "--exclude="))
+		case args[0] == "--parents":
+			parents = true
+		default:
+			return fmt.Errorf(usage)
+		}
+		args = args[1:]
+	}
+
+	if len(args) < 2 {
+		return fmt.Errorf(usage)
+	}
+
+	dst := args[len(args)-1]
+	src := args[:len(args)-1]
+
+	exclude, err := compileMatcher(excludes)
+	if err != nil {
+		return err
+	}
 
-	var realSrc []Entry
+	var (
+		realSrc   []Entry
+		realNames []string
+	)
 
-	for _, n := range srcFS.DeepEntries {
-		for _, p := range src {
-			m, err := filepath.Match(p, n)
+	names, err := srcFS.matchEntries(src)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range names {
+		e, err := srcFS.GetEntry(n)
+		if err != nil {
+			return err
+		}
+
+		if exclude.MatchTree(n, e.isDir()) {
+			continue
+		}
+		if d, ok := e.(*Dir); ok && len(excludes) > 0 {
+			e = d.exclude(n, exclude)
+		}
+
+		realSrc = append(realSrc, e)
+		realNames = append(realNames, n)
+	}
+
+	if parents {
+		for i, src := range realSrc {
+			err := copyParents(dstFS, srcFS, dst, realNames[i], src)
 			if err != nil {
 				return err
 			}
-			if m {
-				e, err := srcFS.GetEntry(n)
-				if err != nil {
-					return err
-				}
-
-				realSrc = append(realSrc, e)
-				break
-			}
 		}
+
+		return nil
 	}
 
 	if len(realSrc) > 1 && !strings.HasSuffix(dst, "/") {
@@ -55,7 +130,7 @@ func applyCOPY(dstFS, srcFS *Dir, op tarOp) error {
 	for _, src := range realSrc {
 		curDst := dst
 		if strings.HasSuffix(curDst, "/") {
-			curDst = path.Join(curDst, path.Base(src.name()))
+			curDst = path.Join(curDst, path.Base(src.Name()))
 		}
 
 		_, err := dstFS.Add(curDst, src)
@@ -64,6 +139,97 @@ func applyCOPY(dstFS, srcFS *Dir, op tarOp) error {
 		}
 	}
 
-	dstFS.BakeDeepEntries()
 	return nil
 }
+
+// copyParents adds src, found at name in srcFS, to dstFS at <dst>/<name>. The
+// missing parent directories are created with the metadata of the source
+// directories.
+func copyParents(dstFS, srcFS *Dir, dst, name string, src Entry) error {
+	var parents []string
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		parents = append([]string{dir}, parents...)
+	}
+
+	for _, dir := range parents {
+		target := path.Join(dst, dir)
+		if _, err := dstFS.GetEntry(target); !os.IsNotExist(err) {
+			continue
+		}
+
+		srcDir, err := srcFS.GetDir(dir)
+		if err != nil {
+			return err
+		}
+
+		d, err := dstFS.MkdirAll(target)
+		if err != nil {
+			return err
+		}
+
+		d.Perm = srcDir.Perm
+		d.User = srcDir.User
+		d.Group = srcDir.Group
+		d.ModTime = srcDir.ModTime
+		d.Touched = srcDir.Touched
+	}
+
+	return mergeEntry(dstFS, path.Join(dst, name), src)
+}
+
+// mergeEntry adds e to dstFS at name. When both e and the existing entry are
+// directories their contents are merged.
+func mergeEntry(dstFS *Dir, name string, e Entry) error {
+	src, ok := e.(*Dir)
+	if !ok {
+		_, err := dstFS.Add(name, e)
+		return err
+	}
+
+	d, err := dstFS.GetDir(name)
+	if os.IsNotExist(err) {
+		_, err = dstFS.Add(name, e)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	d.Perm = src.Perm
+	d.User = src.User
+	d.Group = src.Group
+	d.ModTime = src.ModTime
+	d.Touched = src.Touched
+
+	for _, c := range src.Entries {
+		err := mergeEntry(dstFS, path.Join(name, c.Name()), c)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// exclude returns a copy of d without the entries matched by m. prefix is the
+// path of d in its tree.
+func (d *Dir) exclude(prefix string, m *pathMatcher) *Dir {
+	dst := &Dir{}
+	*dst = *d
+	dst.Entries = nil
+	dst.DeepEntries = nil
+
+	for _, e := range d.Entries {
+		n := path.Join(prefix, e.Name())
+		if m.Match(n, e.isDir()) {
+			continue
+		}
+		if c, ok := e.(*Dir); ok {
+			e = c.exclude(n, m)
+		}
+		dst.Entries = append(dst.Entries, e)
+	}
+
+	dst.bakeDeepEntries()
+	return dst
+}
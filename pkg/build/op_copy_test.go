@@ -1,6 +1,9 @@
 package tarbuild
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 func Test_deepList(t *testing.T) {
 	dir, err := NewDirFromOS("testdata")
@@ -17,3 +20,67 @@ func Test_deepList(t *testing.T) {
 		t.Log(n)
 	}
 }
+
+func Test_applyCOPY_exclude(t *testing.T) {
+	src, err := NewDirFromOS("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewDir()
+	err = applyCOPY(dst, src, Op{Name: "COPY", Args: []string{"--exclude=a-dir/b.txt", "a-dir", "data"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"data", "data/a.txt"}
+	if !reflect.DeepEqual(dst.DeepEntries, expected) {
+		t.Fatalf("expected %q but got %q", expected, dst.DeepEntries)
+	}
+
+	if _, err := src.GetEntry("a-dir/b.txt"); err != nil {
+		t.Fatalf("source was modified: %v", err)
+	}
+}
+
+func Test_applyCOPY_parents(t *testing.T) {
+	src, err := NewDirFromOS("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewDir()
+	err = applyCOPY(dst, src, Op{Name: "COPY", Args: []string{"--parents", "a-dir/*.txt", "out"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"out", "out/a-dir", "out/a-dir/a.txt", "out/a-dir/b.txt"}
+	if !reflect.DeepEqual(dst.DeepEntries, expected) {
+		t.Fatalf("expected %q but got %q", expected, dst.DeepEntries)
+	}
+
+	srcDir, _ := src.GetDir("a-dir")
+	dstDir, _ := dst.GetDir("out/a-dir")
+	if srcDir.Perm != dstDir.Perm {
+		t.Fatalf("expected mode %v but got %v", srcDir.Perm, dstDir.Perm)
+	}
+}
+
+func Test_applyCOPY_negation(t *testing.T) {
+	src, err := NewDirFromOS("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewDir()
+	err = applyCOPY(dst, src, Op{Name: "COPY", Args: []string{"!*.txt", "!.*", "out/"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"out", "out/Tarfile", "out/a-dir", "out/a-dir/a.txt", "out/a-dir/b.txt"}
+	if !reflect.DeepEqual(dst.DeepEntries, expected) {
+		t.Fatalf("expected %q but got %q", expected, dst.DeepEntries)
+	}
+}
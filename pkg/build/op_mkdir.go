@@ -1,12 +1,15 @@
 package tarbuild
 
-func applyMKDIR(dst, src *Dir, op tarOp) error {
+func init() {
+	registerOp("MKDIR", requireArgs, applyMKDIR)
+}
+
+func applyMKDIR(dst, src *Dir, op Op) error {
 	for _, n := range op.Args {
 		_, err := dst.MkdirAll(n)
 		if err != nil {
 			return err
 		}
 	}
-	dst.BakeDeepEntries()
 	return nil
 }
@@ -0,0 +1,76 @@
+package tarbuild
+
+import (
+	"fmt"
+	"strconv"
+)
+
+func init() {
+	registerOp("MKNOD", validateMKNOD, applyMKNOD)
+	registerOp("MKFIFO", requireArgs, applyMKFIFO)
+}
+
+func validateMKNOD(op *Op) error {
+	if len(op.Args) != 4 {
+		return fmt.Errorf("invalid command: %q requires a path, a type and major and minor numbers", op.Name)
+	}
+	return nil
+}
+
+// MKNOD adds a character or block device:
+//
+//	MKNOD <path> c|b <major> <minor>
+//
+// The node is only written to the archive, so no privileges are needed.
+func applyMKNOD(dst, src *Dir, op Op) error {
+	const usage = "usage: MKNOD <path> c|b <major> <minor>"
+
+	if len(op.Args) != 4 {
+		return fmt.Errorf(usage)
+	}
+
+	var typ NodeType
+	switch op.Args[1] {
+	case "c", "u":
+		typ = CharDevice
+	case "b":
+		typ = BlockDevice
+	default:
+		return fmt.Errorf(usage)
+	}
+
+	major, err := strconv.ParseUint(op.Args[2], 0, 32)
+	if err != nil {
+		return fmt.Errorf("MKNOD: invalid major number %q", op.Args[2])
+	}
+
+	minor, err := strconv.ParseUint(op.Args[3], 0, 32)
+	if err != nil {
+		return fmt.Errorf("MKNOD: invalid minor number %q", op.Args[3])
+	}
+
+	_, err = dst.AddNode(op.Args[0], typ, int64(major), int64(minor))
+	if err != nil {
+		return fmt.Errorf("MKNOD %s: %v", op.Args[0], err)
+	}
+
+	return nil
+}
+
+// MKFIFO adds named pipes:
+//
+//	MKFIFO <path>...
+func applyMKFIFO(dst, src *Dir, op Op) error {
+	if len(op.Args) == 0 {
+		return fmt.Errorf("usage: MKFIFO <path>...")
+	}
+
+	for _, n := range op.Args {
+		_, err := dst.AddNode(n, FIFO, 0, 0)
+		if err != nil {
+			return fmt.Errorf("MKFIFO %s: %v", n, err)
+		}
+	}
+
+	return nil
+}
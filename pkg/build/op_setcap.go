@@ -0,0 +1,206 @@
+package tarbuild
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+func init() {
+	registerOp("SETCAP", validateSETCAP, applySETCAP)
+}
+
+func validateSETCAP(op *Op) error {
+	if len(op.Args) < 2 {
+		return fmt.Errorf("invalid command: %q requires capabilities and targets", op.Name)
+	}
+	_, err := encodeFileCaps(op.Args[0])
+	return err
+}
+
+// SETCAP sets the file capabilities of the matching entries, like setcap(8):
+//
+//	SETCAP <caps> <glob>...
+//
+// <caps> uses the cap_from_text(3) syntax, for example
+// `cap_net_bind_service+ep` or `cap_net_raw,cap_net_admin=eip`. Use the JSON
+// form to pass multiple space separated clauses. The capabilities are stored
+// in the security.capability extended attribute. Only regular files get
+// capabilities; directories and other entries matched by <glob> are skipped.
+func applySETCAP(dst, src *Dir, op Op) error {
+	if len(op.Args) < 2 {
+		return fmt.Errorf("usage: SETCAP <caps> <glob>...")
+	}
+
+	value, err := encodeFileCaps(op.Args[0])
+	if err != nil {
+		return err
+	}
+
+	names, err := dst.matchEntries(op.Args[1:])
+	if err != nil {
+		return err
+	}
+
+	for _, n := range names {
+		e, err := dst.GetEntry(n)
+		if err != nil {
+			return err
+		}
+
+		if f, ok := e.(*File); ok {
+			f.setXattr("security.capability", value)
+		}
+	}
+
+	return nil
+}
+
+// capNames are the names of the Linux capabilities, indexed by their number.
+var capNames = []string{
+	"cap_chown",
+	"cap_dac_override",
+	"cap_dac_read_search",
+	"cap_fowner",
+	"cap_fsetid",
+	"cap_kill",
+	"cap_setgid",
+	"cap_setuid",
+	"cap_setpcap",
+	"cap_linux_immutable",
+	"cap_net_bind_service",
+	"cap_net_broadcast",
+	"cap_net_admin",
+	"cap_net_raw",
+	"cap_ipc_lock",
+	"cap_ipc_owner",
+	"cap_sys_module",
+	"cap_sys_rawio",
+	"cap_sys_chroot",
+	"cap_sys_ptrace",
+	"cap_sys_pacct",
+	"cap_sys_admin",
+	"cap_sys_boot",
+	"cap_sys_nice",
+	"cap_sys_resource",
+	"cap_sys_time",
+	"cap_sys_tty_config",
+	"cap_mknod",
+	"cap_lease",
+	"cap_audit_write",
+	"cap_audit_control",
+	"cap_setfcap",
+	"cap_mac_override",
+	"cap_mac_admin",
+	"cap_syslog",
+	"cap_wake_alarm",
+	"cap_block_suspend",
+	"cap_audit_read",
+	"cap_perfmon",
+	"cap_bpf",
+	"cap_checkpoint_restore",
+}
+
+const (
+	vfsCapRevision2       = 0x02000000
+	vfsCapFlagsEffective  = 0x000001
+	vfsCapDataSizeVersion = 20
+)
+
+// encodeFileCaps encodes a textual capability set as a revision 2
+// vfs_cap_data structure, the value of the security.capability attribute.
+func encodeFileCaps(text string) (string, error) {
+	var (
+		permitted   uint64
+		inheritable uint64
+		effective   uint64
+	)
+
+	for _, clause := range strings.Fields(text) {
+		idx := strings.IndexAny(clause, "=+-")
+		if idx < 0 {
+			return "", fmt.Errorf("invalid capabilities %q: missing operator", text)
+		}
+
+		var caps uint64
+		for _, name := range strings.Split(clause[:idx], ",") {
+			c, err := parseCapName(name)
+			if err != nil {
+				return "", fmt.Errorf("invalid capabilities %q: %v", text, err)
+			}
+			caps |= c
+		}
+
+		ops := clause[idx:]
+		for len(ops) > 0 {
+			op := ops[0]
+			ops = ops[1:]
+
+			end := strings.IndexAny(ops, "=+-")
+			if end < 0 {
+				end = len(ops)
+			}
+			flags := ops[:end]
+			ops = ops[end:]
+
+			if op == '=' {
+				permitted &^= caps
+				inheritable &^= caps
+				effective &^= caps
+			}
+
+			for _, f := range flags {
+				var set *uint64
+				switch f {
+				case 'p':
+					set = &permitted
+				case 'i':
+					set = &inheritable
+				case 'e':
+					set = &effective
+				default:
+					return "", fmt.Errorf("invalid capabilities %q: unknown flag %q", text, f)
+				}
+
+				if op == '-' {
+					*set &^= caps
+				} else {
+					*set |= caps
+				}
+			}
+		}
+	}
+
+	if permitted == 0 && inheritable == 0 {
+		return "", fmt.Errorf("invalid capabilities %q: no permitted or inheritable capabilities", text)
+	}
+
+	magic := uint32(vfsCapRevision2)
+	if effective != 0 {
+		magic |= vfsCapFlagsEffective
+	}
+
+	buf := make([]byte, vfsCapDataSizeVersion)
+	binary.LittleEndian.PutUint32(buf[0:], magic)
+	binary.LittleEndian.PutUint32(buf[4:], uint32(permitted))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(inheritable))
+	binary.LittleEndian.PutUint32(buf[12:], uint32(permitted>>32))
+	binary.LittleEndian.PutUint32(buf[16:], uint32(inheritable>>32))
+	return string(buf), nil
+}
+
+func parseCapName(name string) (uint64, error) {
+	name = strings.ToLower(name)
+
+	if name == "" || name == "all" {
+		return 1<<uint(len(capNames)) - 1, nil
+	}
+
+	for i, n := range capNames {
+		if n == name {
+			return 1 << uint(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown capability %q", name)
+}
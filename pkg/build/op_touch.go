@@ -0,0 +1,60 @@
+package tarbuild
+
+import (
+	"fmt"
+	"time"
+)
+
+func init() {
+	registerOp("TOUCH", requireArgs, applyTOUCH)
+}
+
+// TOUCH sets the modification time of the matching entries:
+//
+//	TOUCH [-d <time>] <glob>...
+//
+// <time> is an RFC 3339 time (2024-01-01T00:00:00Z), a date and time in UTC
+// (2024-01-01 00:00:00), a date (2024-01-01) or a unix timestamp (@1704067200).
+// Without -d the entries get the time of the build (--mtime), even when the
+// times of the context are preserved. Touched entries keep their time
+// regardless of the --mtime mode.
+func applyTOUCH(dst, src *Dir, op Op) error {
+	const usage = "usage: TOUCH [-d <time>] <glob>..."
+
+	var (
+		args  = op.Args
+		mtime time.Time
+	)
+
+	if len(args) >= 1 && args[0] == "-d" {
+		if len(args) < 2 {
+			return fmt.Errorf(usage)
+		}
+		t, err := parseTime(args[1])
+		if err != nil {
+			return err
+		}
+		mtime = t
+		args = args[2:]
+	}
+
+	if len(args) == 0 {
+		return fmt.Errorf(usage)
+	}
+
+	names, err := dst.matchEntries(args)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range names {
+		e, err := dst.GetEntry(n)
+		if err != nil {
+			return err
+		}
+
+		e.touch(mtime)
+	}
+
+	return nil
+}
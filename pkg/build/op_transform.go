@@ -0,0 +1,251 @@
+package tarbuild
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+func init() {
+	registerOp("TRANSFORM", validateTRANSFORM, applyTRANSFORM)
+}
+
+func validateTRANSFORM(op *Op) error {
+	if len(op.Args) == 0 {
+		return fmt.Errorf("invalid command: %q requires arguments", op.Name)
+	}
+	for _, expr := range op.Args {
+		if _, err := parseTransform(expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TRANSFORM renames paths in the destination tree, like the --transform
+// option of GNU tar:
+//
+//   TRANSFORM s/<regex>/<replacement>/[flags]...
+//
+// Any character can be used as the delimiter instead of `/`. The regex uses
+// the Go (RE2) syntax and is matched against the path of every entry (without
+// a leading `/`); the paths of directories end with a `/`. In the
+// replacement `&` and `\0` refer to the whole match and `\1` to `\9` to the
+// sub-matches. The `g` flag replaces all matches instead of only the first
+// one and the `i` flag makes the match case insensitive. Multiple expressions
+// are applied in order.
+//
+// Entries renamed to an empty path are dropped, so `s,^src/,,` moves the
+// entries of src to the root. It is an error for two entries to end up with
+// the same path.
+func applyTRANSFORM(dst, src *Dir, op Op) error {
+	if len(op.Args) == 0 {
+		return fmt.Errorf("usage: TRANSFORM s/<regex>/<replacement>/[flags]...")
+	}
+
+	var transforms []*transform
+	for _, expr := range op.Args {
+		t, err := parseTransform(expr)
+		if err != nil {
+			return err
+		}
+		transforms = append(transforms, t)
+	}
+
+	return dst.rename(func(name string) string {
+		for _, t := range transforms {
+			name = t.apply(name)
+		}
+		return name
+	})
+}
+
+type transform struct {
+	re          *regexp.Regexp
+	replacement string
+	global      bool
+}
+
+func parseTransform(expr string) (*transform, error) {
+	if len(expr) < 2 || expr[0] != 's' {
+		return nil, fmt.Errorf("invalid transform %q: expected s/<regex>/<replacement>/[flags]", expr)
+	}
+
+	var (
+		delim = expr[1]
+		parts []string
+		buf   strings.Builder
+	)
+
+	for i := 2; i < len(expr); i++ {
+		c := expr[i]
+		if c == '\\' && i+1 < len(expr) && expr[i+1] == delim {
+			buf.WriteByte(delim)
+			i++
+			continue
+		}
+		if c == '\\' && i+1 < len(expr) {
+			buf.WriteByte(c)
+			buf.WriteByte(expr[i+1])
+			i++
+			continue
+		}
+		if c == delim {
+			parts = append(parts, buf.String())
+			buf.Reset()
+			continue
+		}
+		buf.WriteByte(c)
+	}
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid transform %q: expected s/<regex>/<replacement>/[flags]", expr)
+	}
+
+	var (
+		t       = &transform{}
+		pattern = parts[0]
+		flags   = buf.String()
+	)
+
+	for _, f := range flags {
+		switch f {
+		case 'g':
+			t.global = true
+		case 'i':
+			pattern = "(?i)" + pattern
+		case 'x':
+			// Go regular expressions are always extended.
+		default:
+			return nil, fmt.Errorf("invalid transform %q: unsupported flag %q", expr, f)
+		}
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transform %q: %v", expr, err)
+	}
+	t.re = re
+
+	var repl strings.Builder
+	for i := 0; i < len(parts[1]); i++ {
+		c := parts[1][i]
+		switch {
+		case c == '&':
+			repl.WriteString("${0}")
+		case c == '$':
+			repl.WriteString("$$")
+		case c == '\\' && i+1 < len(parts[1]):
+			i++
+			c = parts[1][i]
+			if '0' <= c && c <= '9' {
+				repl.WriteString("${" + string(c) + "}")
+			} else if c == '$' {
+				repl.WriteString("$$")
+			} else {
+				repl.WriteByte(c)
+			}
+		default:
+			repl.WriteByte(c)
+		}
+	}
+	t.replacement = repl.String()
+
+	return t, nil
+}
+
+func (t *transform) apply(name string) string {
+	if t.global {
+		return t.re.ReplaceAllString(name, t.replacement)
+	}
+
+	loc := t.re.FindStringSubmatchIndex(name)
+	if loc == nil {
+		return name
+	}
+
+	out := name[:loc[0]]
+	out += string(t.re.ExpandString(nil, t.replacement, name, loc))
+	out += name[loc[1]:]
+	return out
+}
+
+// rename moves every entry of d to the path returned by fn.
+func (d *Dir) rename(fn func(name string) string) error {
+	var (
+		names   = map[string]string{}
+		renamed []string
+	)
+
+	for _, n := range d.DeepEntries {
+		e, err := d.GetEntry(n)
+		if err != nil {
+			return err
+		}
+
+		// Directories are named with a trailing slash, like in tar archives.
+		name := n
+		if e.isDir() {
+			name += "/"
+		}
+
+		newName := path.Join(".", path.Join("/", fn(name)))
+		if newName == "." {
+			// Dropped like GNU tar does, the entries of a directory are
+			// renamed on their own.
+			continue
+		}
+		if other, found := names[newName]; found {
+			return fmt.Errorf("TRANSFORM: %q and %q are both renamed to %q", other, n, newName)
+		}
+		names[newName] = n
+		renamed = append(renamed, newName)
+	}
+
+	sort.Strings(renamed)
+
+	root := &Dir{}
+	*root = *d
+	root.Entries = nil
+	root.DeepEntries = nil
+
+	for _, newName := range renamed {
+		n := names[newName]
+
+		e, err := d.GetEntry(n)
+		if err != nil {
+			return err
+		}
+
+		src, ok := e.(*Dir)
+		if !ok {
+			_, err := root.Add(newName, e)
+			if err != nil {
+				return fmt.Errorf("TRANSFORM: unable to rename %q to %q: %v", n, newName, err)
+			}
+			continue
+		}
+
+		parent, err := root.MkdirAll(path.Dir(newName))
+		if err != nil {
+			return fmt.Errorf("TRANSFORM: unable to rename %q to %q: %v", n, newName, err)
+		}
+
+		dir := &Dir{}
+		*dir = *src
+		dir.name = path.Base(newName)
+		dir.Entries = nil
+		dir.DeepEntries = nil
+
+		if _, err := parent.GetEntry(dir.name); !os.IsNotExist(err) {
+			return fmt.Errorf("TRANSFORM: unable to rename %q to %q: %v", n, newName, os.ErrExist)
+		}
+		root.insert(newName, parent, dir)
+	}
+
+	d.Entries = root.Entries
+	d.DeepEntries = root.DeepEntries
+	return nil
+}
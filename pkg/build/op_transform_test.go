@@ -0,0 +1,92 @@
+package tarbuild
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_parseTransform(t *testing.T) {
+	tests := []struct {
+		expr     string
+		name     string
+		expected string
+	}{
+		{`s,^src/,app/,`, "src/main.go", "app/main.go"},
+		{`s,^src/,app/,`, "lib/src/main.go", "lib/src/main.go"},
+		{`s/a/b/`, "a/a", "b/a"},
+		{`s/a/b/g`, "a/a", "b/b"},
+		{`s/A/b/gi`, "a/a", "b/b"},
+		{`s/(.*)\.txt$/\1.md/`, "docs/a.txt", "docs/a.md"},
+		{`s/.*/&.bak/`, "a", "a.bak"},
+		{`s/a/$1/`, "a", "$1"},
+		{`s|/|\||g`, "a/b", "a|b"},
+	}
+
+	for _, test := range tests {
+		tr, err := parseTransform(test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+
+		actual := tr.apply(test.name)
+		if actual != test.expected {
+			t.Errorf("%s: expected %q but got %q", test.expr, test.expected, actual)
+		}
+	}
+}
+
+func Test_applyTRANSFORM(t *testing.T) {
+	src, err := NewDirFromOS("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewDir()
+	err = applyCOPY(dst, src, Op{Name: "COPY", Args: []string{"a-dir", "src/data"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = applyTRANSFORM(dst, src, Op{Name: "TRANSFORM", Args: []string{`s,^src/,app/,`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"app", "app/data", "app/data/a.txt", "app/data/b.txt"}
+	if !reflect.DeepEqual(dst.DeepEntries, expected) {
+		t.Fatalf("expected %q but got %q", expected, dst.DeepEntries)
+	}
+
+	err = applyTRANSFORM(dst, src, Op{Name: "TRANSFORM", Args: []string{`s,[ab]\.txt$,x.txt,`}})
+	if err == nil {
+		t.Fatal("expected a collision")
+	}
+	if !strings.Contains(err.Error(), `"app/data/a.txt" and "app/data/b.txt"`) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func Test_applyTRANSFORM_stripPrefix(t *testing.T) {
+	src, err := NewDirFromOS("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewDir()
+	err = applyCOPY(dst, src, Op{Name: "COPY", Args: []string{"a-dir", "src/data"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = applyTRANSFORM(dst, src, Op{Name: "TRANSFORM", Args: []string{`s,^src/,,`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"data", "data/a.txt", "data/b.txt"}
+	if !reflect.DeepEqual(dst.DeepEntries, expected) {
+		t.Fatalf("expected %q but got %q", expected, dst.DeepEntries)
+	}
+}
@@ -0,0 +1,57 @@
+package tarbuild
+
+import "fmt"
+
+func init() {
+	registerOp("XATTR", validateXATTR, applyXATTR)
+}
+
+func validateXATTR(op *Op) error {
+	if len(op.Args) < 3 {
+		return fmt.Errorf("invalid command: %q requires a name, a value and targets", op.Name)
+	}
+	return nil
+}
+
+// XATTR sets an extended attribute on the matching entries:
+//
+//	XATTR <name> <value> <glob>...
+//
+// Values prefixed with 0x are hex encoded and values prefixed with 0s are
+// base64 encoded, like with setfattr(1). The attributes are written as
+// SCHILY.xattr PAX records.
+func applyXATTR(dst, src *Dir, op Op) error {
+	if len(op.Args) < 3 {
+		return fmt.Errorf("usage: XATTR <name> <value> <glob>...")
+	}
+
+	var (
+		name  = op.Args[0]
+		paths = op.Args[2:]
+	)
+
+	value, err := parseXattrValue(op.Args[1])
+	if err != nil {
+		return err
+	}
+
+	return setXattr(dst, name, value, paths)
+}
+
+func setXattr(dst *Dir, name, value string, paths []string) error {
+	names, err := dst.matchEntries(paths)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range names {
+		e, err := dst.GetEntry(n)
+		if err != nil {
+			return err
+		}
+
+		e.setXattr(name, value)
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package tarbuild
+
+import "fmt"
+
+// Output is the kind of archive written by Build.
+type Output string
+
+const (
+	// OutputTar writes a tar archive. It is the default.
+	OutputTar Output = ""
+
+	// OutputCPIO writes a cpio archive in the newc format. The header
+	// format of the build is ignored.
+	OutputCPIO Output = "cpio"
+
+	// OutputZIP writes a zip archive. The header format of the build is
+	// ignored.
+	OutputZIP Output = "zip"
+)
+
+// ParseOutput parses the name of an output: tar, cpio or zip.
+func ParseOutput(name string) (Output, error) {
+	switch o := Output(name); o {
+	case "tar":
+		return OutputTar, nil
+	case OutputTar, OutputCPIO, OutputZIP:
+		return o, nil
+	}
+	return OutputTar, fmt.Errorf("invalid output %q: expected tar, cpio or zip", name)
+}
+
+// WithOutput sets the kind of archive written by Build.
+func WithOutput(o Output) Option {
+	return func(c *buildConfig) {
+		c.output = o
+	}
+}
@@ -0,0 +1,228 @@
+package tarbuild
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// pluginPrefix is the prefix of the executables implementing commands which
+// are not registered: FOO runs x-tar-op-foo from the PATH.
+const pluginPrefix = "x-tar-op-"
+
+// PluginRequest is written as JSON to the stdin of a plugin. Tree lists the
+// entries of the archive so far, without digests.
+type PluginRequest struct {
+	Op   string          `json:"op"`
+	Args []string        `json:"args"`
+	Tree []ManifestEntry `json:"tree"`
+}
+
+// PluginResponse is read as JSON from the stdout of a plugin. The mutations
+// are applied in order.
+type PluginResponse struct {
+	Mutations []PluginMutation `json:"mutations"`
+}
+
+// PluginMutation is a change made by a plugin. Action is one of:
+//
+//	add      writes a file at Path with Content, or with Data when it is binary
+//	mkdir    creates the directory Path and its parents
+//	symlink  adds a symbolic link at Path pointing to Target
+//	chmod    sets the mode of Path
+//	chown    sets the owner of Path, as <user>, <user>:<group> or :<group>
+//	remove   removes Path and its entries
+//
+// Mode and Owner may also be set with add, mkdir and symlink. Recursive
+// applies chmod and chown to the entries of a directory too.
+type PluginMutation struct {
+	Action    string `json:"action"`
+	Path      string `json:"path"`
+	Content   string `json:"content,omitempty"`
+	Data      []byte `json:"data,omitempty"`
+	Target    string `json:"target,omitempty"`
+	Mode      string `json:"mode,omitempty"`
+	Owner     string `json:"owner,omitempty"`
+	Recursive bool   `json:"recursive,omitempty"`
+}
+
+// lookupPlugin returns the path of the x-tar-op-<name> executable found on
+// the PATH.
+func lookupPlugin(name string) (string, bool) {
+	if !isOpName(name) {
+		return "", false
+	}
+
+	file, err := exec.LookPath(pluginPrefix + strings.ToLower(name))
+	if err != nil {
+		return "", false
+	}
+	return file, true
+}
+
+// applyPlugin runs the plugin of op in wd, the build context, and applies
+// its mutations to dst. The plugin is killed when ctx is done.
+func applyPlugin(ctx context.Context, wd string, dst *Dir, op Op) error {
+	file := op.plugin
+
+	tree, err := pluginTree(dst)
+	if err != nil {
+		return fmt.Errorf("%s: %v", op.Name, err)
+	}
+
+	req, err := json.Marshal(PluginRequest{Op: op.Name, Args: op.Args, Tree: tree})
+	if err != nil {
+		return fmt.Errorf("%s: %v", op.Name, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, file, op.Args...)
+	cmd.Dir = wd
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %s: %v: %s", op.Name, file, err, msg)
+		}
+		return fmt.Errorf("%s: %s: %v", op.Name, file, err)
+	}
+
+	var resp PluginResponse
+	err = json.Unmarshal(stdout.Bytes(), &resp)
+	if err != nil {
+		return fmt.Errorf("%s: %s: invalid response: %v", op.Name, file, err)
+	}
+
+	for _, m := range resp.Mutations {
+		err := applyMutation(dst, m)
+		if err != nil {
+			return fmt.Errorf("%s: %s %s: %v", op.Name, m.Action, m.Path, err)
+		}
+	}
+
+	return nil
+}
+
+// pluginTree describes the entries of d like a manifest, without digests.
+func pluginTree(d *Dir) ([]ManifestEntry, error) {
+	w := &tarWriter{preserveModTime: true}
+
+	tree := []ManifestEntry{}
+	err := d.walk("", func(path string, e Entry) error {
+		h, err := e.tarHeader(path, w)
+		if err != nil {
+			return err
+		}
+
+		tree = append(tree, ManifestEntry{
+			Path:   path,
+			Type:   manifestTypes[h.Typeflag],
+			Mode:   fmt.Sprintf("%04o", h.Mode&07777),
+			Owner:  h.Uname + ":" + h.Gname,
+			Size:   h.Size,
+			Target: h.Linkname,
+		})
+		return nil
+	})
+	return tree, err
+}
+
+func applyMutation(dst *Dir, m PluginMutation) error {
+	var (
+		e   Entry
+		err error
+	)
+
+	switch m.Action {
+	case "add":
+		err = removeFile(dst, m.Path)
+		if err != nil {
+			return err
+		}
+		var f *File
+		f, err = dst.AddFile(m.Path, "")
+		if err == nil {
+			f.Data = []byte(m.Content)
+			if m.Data != nil {
+				f.Data = m.Data
+			}
+		}
+		e = f
+
+	case "mkdir":
+		e, err = dst.MkdirAll(m.Path)
+
+	case "symlink":
+		if m.Target == "" {
+			return fmt.Errorf("missing target")
+		}
+		err = removeFile(dst, m.Path)
+		if err != nil {
+			return err
+		}
+		e, err = dst.AddSymlink(m.Path, m.Target)
+
+	case "chmod":
+		if m.Mode == "" {
+			return fmt.Errorf("missing mode")
+		}
+		e, err = dst.GetEntry(m.Path)
+
+	case "chown":
+		if m.Owner == "" {
+			return fmt.Errorf("missing owner")
+		}
+		e, err = dst.GetEntry(m.Path)
+
+	case "remove":
+		return dst.Remove(m.Path)
+
+	default:
+		return fmt.Errorf("unknown action %q", m.Action)
+	}
+	if err != nil {
+		return err
+	}
+
+	if m.Mode != "" {
+		mode, err := strconv.ParseUint(m.Mode, 8, 32)
+		if err != nil || mode > 07777 {
+			return fmt.Errorf("invalid mode %q", m.Mode)
+		}
+		e.chmod(07777, uint32(mode), m.Recursive)
+	}
+	if m.Owner != "" {
+		user, group := m.Owner, ""
+		if idx := strings.IndexByte(user, ':'); idx >= 0 {
+			group = user[idx+1:]
+			user = user[:idx]
+		}
+		e.chown(user, group, m.Recursive)
+	}
+
+	return nil
+}
+
+// removeFile removes name unless it is missing. Directories are kept, adding
+// a file over one fails.
+func removeFile(d *Dir, name string) error {
+	e, err := d.GetEntry(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if e.isDir() {
+		return os.ErrExist
+	}
+	return d.Remove(name)
+}
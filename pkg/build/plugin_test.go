@@ -0,0 +1,160 @@
+package tarbuild
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+)
+
+const testPlugin = `#!/bin/sh
+if [ "$1" = fail ]; then
+	echo "something went wrong" >&2
+	exit 1
+fi
+if [ "$1" = sleep ]; then
+	exec sleep 10
+fi
+req=$(base64 | tr -d '\n')
+cat <<EOF
+{"mutations": [
+	{"action": "add", "path": "etc/request.json", "data": "$req", "mode": "0600", "owner": "app:app"},
+	{"action": "add", "path": "etc/motd", "content": "hello $1\n"},
+	{"action": "add", "path": "etc/pwd", "content": "$(pwd)"},
+	{"action": "mkdir", "path": "var/lib/app", "owner": "app"},
+	{"action": "symlink", "path": "etc/link", "target": "motd"},
+	{"action": "chmod", "path": "data", "mode": "0700", "recursive": true},
+	{"action": "remove", "path": "data/b.txt"}
+]}
+EOF
+`
+
+func TestPlugin(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the test plugin is a shell script")
+	}
+
+	dir, err := ioutil.TempDir("", "tarbuild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "x-tar-op-testplugin"), []byte(testPlugin), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	tarfile := `
+COPY a-dir data
+CHMOD 0644 data/a.txt
+TESTPLUGIN world
+`
+
+	var buf bytes.Buffer
+	err = Build(&buf, "testdata", writeTarfile(t, tarfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDirFromTar(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := d.ReadFile("etc/request.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req PluginRequest
+	err = json.Unmarshal(data, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Op != "TESTPLUGIN" || !reflect.DeepEqual(req.Args, []string{"world"}) {
+		t.Errorf("unexpected request %+v", req)
+	}
+	found := false
+	for _, e := range req.Tree {
+		if e.Path == "data/a.txt" {
+			found = true
+			if e.Type != "file" || e.Mode != "0644" || e.Owner != "root:root" {
+				t.Errorf("unexpected entry %+v", e)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("data/a.txt is missing from the tree %+v", req.Tree)
+	}
+
+	data, err = d.ReadFile("etc/motd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello world\n" {
+		t.Errorf("unexpected contents %q", data)
+	}
+
+	// The plugin runs in the build context.
+	data, err = d.ReadFile("etc/pwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != wd {
+		t.Errorf("expected the plugin to run in %s but got %q", wd, data)
+	}
+
+	expected := map[string]string{
+		"etc/request.json": "-rw------- app:app",
+		"var/lib/app":      "drwxr-xr-x app:root",
+		"etc/link":         "Lrwxrwxrwx root:root",
+		"data":             "drwx------ root:root",
+		"data/a.txt":       "-rwx------ root:root",
+	}
+	for name, want := range expected {
+		e, err := d.GetEntry(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		user, group := e.Owner()
+		if got := e.Mode().String() + " " + user + ":" + group; got != want {
+			t.Errorf("%s: expected %s but got %s", name, want, got)
+		}
+	}
+	if _, err := d.GetEntry("data/b.txt"); !os.IsNotExist(err) {
+		t.Errorf("expected data/b.txt to be removed but got %v", err)
+	}
+
+	err = Build(&buf, "testdata", writeTarfile(t, "TESTPLUGIN fail\n"))
+	if err == nil || !bytes.Contains([]byte(err.Error()), []byte("something went wrong")) {
+		t.Errorf("expected the error of the plugin but got %v", err)
+	}
+
+	// The plugin is killed when the build is canceled.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = BuildContext(ctx, &buf, "testdata", writeTarfile(t, "TESTPLUGIN sleep\n"))
+	if err == nil || time.Since(start) > 5*time.Second {
+		t.Errorf("expected the plugin to be killed but got %v after %v", err, time.Since(start))
+	}
+
+	err = Build(&buf, "testdata", writeTarfile(t, "NOSUCHPLUGIN\n"))
+	if err == nil {
+		t.Error("expected an unknown command to fail")
+	}
+}
@@ -0,0 +1,124 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"strings"
+)
+
+// EventKind is the kind of an Event.
+type EventKind string
+
+const (
+	EventOpStarted  EventKind = "op-started"
+	EventOpFinished EventKind = "op-finished"
+
+	// EventEntry is sent as an entry of the archive is written.
+	EventEntry EventKind = "entry"
+)
+
+// Event reports the progress of a build.
+type Event struct {
+	Kind EventKind
+
+	// Op is the command of op events.
+	Op Op
+
+	// Path and Size describe the entry of entry events.
+	Path string
+	Size int64
+
+	// N and Total count the commands of op events, or the entries of entry
+	// events. N starts at 1.
+	N     int
+	Total int
+
+	// Bytes is the size of the contents written so far, this entry
+	// included, and TotalBytes the size of all the contents. They are only
+	// set for entry events.
+	Bytes      int64
+	TotalBytes int64
+}
+
+// WithProgress calls fn as the commands are applied and the entries are
+// written. fn is called from the goroutine of the build.
+func WithProgress(fn func(Event)) Option {
+	return func(c *buildConfig) {
+		c.progress = fn
+	}
+}
+
+func (r *result) event(e Event) {
+	if r.cfg.progress != nil {
+		r.cfg.progress(e)
+	}
+}
+
+// writeProgress counts the entries written by result.write.
+type writeProgress struct {
+	n          int
+	total      int
+	bytes      int64
+	totalBytes int64
+}
+
+// startWrite counts the entries of the archive to report the progress of
+// write.
+func (r *result) startWrite() error {
+	p := &writeProgress{}
+
+	if r.cfg.progress != nil {
+		err := r.tree.walk("", func(path string, e Entry) error {
+			p.total++
+			if f, ok := e.(*File); ok {
+				size, err := f.size()
+				if err != nil {
+					return err
+				}
+				p.totalBytes += size
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	r.writing = p
+	return nil
+}
+
+// writeEntry is called before an entry of the archive is written. It stops
+// the build when its context is done.
+func (r *result) writeEntry(path string, size int64) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+
+	p := r.writing
+	if p == nil {
+		return nil
+	}
+
+	p.n++
+	p.bytes += size
+	r.event(Event{
+		Kind:       EventEntry,
+		Path:       path,
+		Size:       size,
+		N:          p.n,
+		Total:      p.total,
+		Bytes:      p.bytes,
+		TotalBytes: p.totalBytes,
+	})
+	return nil
+}
+
+// writeTarEntry is the writeEntry of tar headers. Only the contents of files
+// are counted.
+func (r *result) writeTarEntry(h *tar.Header) error {
+	var size int64
+	if h.Typeflag == tar.TypeReg {
+		size = h.Size
+	}
+	return r.writeEntry(strings.TrimSuffix(h.Name, "/"), size)
+}
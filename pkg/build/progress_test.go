@@ -0,0 +1,127 @@
+package tarbuild
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestBuildContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var buf bytes.Buffer
+	err := BuildContext(ctx, &buf, "testdata", writeTarfile(t, "COPY a-dir data\n"))
+	if err != context.Canceled {
+		t.Errorf("expected %v but got %v", context.Canceled, err)
+	}
+
+	// Cancel the build while the archive is written.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	var entries int
+	err = BuildContext(ctx, &buf, "testdata", writeTarfile(t, "COPY a-dir data\n"), WithProgress(func(e Event) {
+		if e.Kind == EventEntry {
+			entries++
+			cancel()
+		}
+	}))
+	if err != context.Canceled {
+		t.Errorf("expected %v but got %v", context.Canceled, err)
+	}
+	if entries != 1 {
+		t.Errorf("expected the build to stop after the first entry but got %d", entries)
+	}
+}
+
+func TestBuildContext_outputs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	conf := writeTarfile(t, "COPY a-dir data\n")
+	spec := NewBuilder("testdata").Copy("a-dir", "data")
+
+	builds := map[string]func() error{
+		"spec": func() error {
+			return BuildSpecContext(ctx, io.Discard, "testdata", spec.Spec())
+		},
+		"builder": func() error {
+			return spec.WriteContext(ctx, io.Discard)
+		},
+		"deb": func() error {
+			return BuildDebContext(ctx, io.Discard, "testdata", conf)
+		},
+		"layers": func() error {
+			_, err := BuildLayersContext(ctx, "testdata", conf)
+			return err
+		},
+		"oci": func() error {
+			return BuildOCILayoutContext(ctx, t.TempDir(), "testdata", conf, ImageConfig{})
+		},
+		"docker": func() error {
+			return BuildDockerImageContext(ctx, io.Discard, "app:latest", "testdata", conf, ImageConfig{})
+		},
+		"verify": func() error {
+			return VerifyReproducibleContext(ctx, "testdata", conf)
+		},
+	}
+	for name, fn := range builds {
+		if err := fn(); err != context.Canceled {
+			t.Errorf("%s: expected %v but got %v", name, context.Canceled, err)
+		}
+	}
+}
+
+func TestWithProgress(t *testing.T) {
+	tarfile := `
+COPY a-dir data
+MKDIR empty
+TESTGREET hello.txt world
+`
+
+	for _, output := range []Output{OutputTar, OutputCPIO, OutputZIP} {
+		var events []Event
+		err := Build(&bytes.Buffer{}, "testdata", writeTarfile(t, tarfile), WithOutput(output), WithProgress(func(e Event) {
+			events = append(events, e)
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var (
+			ops     []string
+			entries []string
+		)
+		for _, e := range events {
+			switch e.Kind {
+			case EventOpStarted, EventOpFinished:
+				ops = append(ops, string(e.Kind)+" "+e.Op.Name)
+				if e.Total != 3 {
+					t.Errorf("%s: expected 3 commands but got %d", output, e.Total)
+				}
+			case EventEntry:
+				entries = append(entries, e.Path)
+				if e.Total != 5 {
+					t.Errorf("%s: expected 5 entries but got %d", output, e.Total)
+				}
+			}
+		}
+
+		expected := []string{"op-started COPY", "op-finished COPY", "op-started MKDIR", "op-finished MKDIR", "op-started TESTGREET", "op-finished TESTGREET"}
+		if !reflect.DeepEqual(ops, expected) {
+			t.Errorf("%s: expected %q but got %q", output, expected, ops)
+		}
+		expected = []string{"data", "data/a.txt", "data/b.txt", "empty", "hello.txt"}
+		if !reflect.DeepEqual(entries, expected) {
+			t.Errorf("%s: expected %q but got %q", output, expected, entries)
+		}
+
+		last := events[len(events)-1]
+		if last.N != last.Total || last.Bytes != 12 || last.TotalBytes != 12 {
+			t.Errorf("%s: unexpected last event %+v", output, last)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package tarbuild
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ValidateFunc checks the arguments of a command when the Tarfile is loaded,
+// before anything is built. It may complete the arguments.
+type ValidateFunc func(op *Op) error
+
+// ApplyFunc applies a command to dst, the tree of the archive. src is the
+// build context.
+type ApplyFunc func(dst, src *Dir, op Op) error
+
+type registeredOp struct {
+	validate ValidateFunc
+	apply    ApplyFunc
+}
+
+var (
+	opsMu sync.RWMutex
+	ops   = map[string]registeredOp{}
+)
+
+// RegisterOp adds a command to the Tarfile format, like the built-in COPY or
+// CHMOD. Names are made of upper case letters; validate may be nil. It is
+// meant to be called from init functions and panics when the name is invalid
+// or already taken.
+func RegisterOp(name string, validate ValidateFunc, apply ApplyFunc) {
+	if apply == nil {
+		panic(fmt.Sprintf("tarbuild: command %q has no apply function", name))
+	}
+	registerOp(name, validate, apply)
+}
+
+// registerOp also takes directives without an apply function, like LAYER or
+// ORDER, which are handled by the build itself.
+func registerOp(name string, validate ValidateFunc, apply ApplyFunc) {
+	if !isOpName(name) {
+		panic(fmt.Sprintf("tarbuild: invalid command name %q", name))
+	}
+
+	opsMu.Lock()
+	defer opsMu.Unlock()
+
+	if _, found := ops[name]; found {
+		panic(fmt.Sprintf("tarbuild: command %q is already registered", name))
+	}
+	ops[name] = registeredOp{validate: validate, apply: apply}
+}
+
+// lookupOp returns a registered command. Plugins are looked up by
+// Op.validate.
+func lookupOp(name string) (registeredOp, bool) {
+	opsMu.RLock()
+	defer opsMu.RUnlock()
+
+	o, found := ops[name]
+	return o, found
+}
+
+func isOpName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// requireArgs is the ValidateFunc of commands which take one or more
+// arguments.
+func requireArgs(op *Op) error {
+	if len(op.Args) == 0 {
+		return fmt.Errorf("invalid command: %q requires arguments", op.Name)
+	}
+	return nil
+}
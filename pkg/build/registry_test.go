@@ -0,0 +1,66 @@
+package tarbuild
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func init() {
+	RegisterOp("TESTGREET", func(op *Op) error {
+		if len(op.Args) != 2 {
+			return fmt.Errorf("invalid command: %q requires a path and a name", op.Name)
+		}
+		return nil
+	}, func(dst, src *Dir, op Op) error {
+		f, err := dst.AddFile(op.Args[0], "")
+		if err != nil {
+			return err
+		}
+		f.Data = []byte("hello " + op.Args[1] + "\n")
+		return nil
+	})
+
+	// TESTSYMLINK <target> <path> adds a symbolic link to the tree.
+	RegisterOp("TESTSYMLINK", nil, func(dst, src *Dir, op Op) error {
+		_, err := dst.AddSymlink(op.Args[1], op.Args[0])
+		return err
+	})
+}
+
+func TestRegisterOp(t *testing.T) {
+	var buf bytes.Buffer
+	err := Build(&buf, "testdata", writeTarfile(t, "TESTGREET greeting.txt world\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDirFromTar(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := d.ReadFile("greeting.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello world\n" {
+		t.Errorf("unexpected contents %q", data)
+	}
+
+	err = NewBuilder("testdata").Op("TESTGREET", "greeting.txt").Write(io.Discard)
+	if err == nil {
+		t.Error("expected the validation to fail")
+	}
+
+	for _, name := range []string{"COPY", "TESTGREET", "lower", ""} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%q: expected a panic", name)
+				}
+			}()
+			RegisterOp(name, nil, applyMKDIR)
+		}()
+	}
+}
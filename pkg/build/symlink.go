@@ -0,0 +1,137 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// Symlink is a symbolic link. The target is not resolved.
+type Symlink struct {
+	name    string
+	Target  string
+	User    string
+	Group   string
+	ModTime time.Time
+	Touched bool
+	Xattrs  map[string]string
+}
+
+// AddSymlink adds a new symbolic link to d.
+func (d *Dir) AddSymlink(name, target string) (*Symlink, error) {
+	name = path.Join(".", path.Join("/", name))
+
+	dirName, fileName := path.Split(name)
+	dirName = path.Join(".", path.Join("/", dirName))
+	parent, err := d.MkdirAll(dirName)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = parent.GetEntry(fileName)
+	if err == nil {
+		err = os.ErrExist
+	}
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	link := &Symlink{
+		name:   fileName,
+		Target: target,
+		User:   "root",
+		Group:  "root",
+	}
+
+	d.insert(name, parent, link)
+	return link, nil
+}
+
+func (l *Symlink) isDir() bool {
+	return false
+}
+
+func (l *Symlink) Name() string {
+	return l.name
+}
+
+func (l *Symlink) clone(name string) Entry {
+	dst := &Symlink{}
+	*dst = *l
+	dst.name = name
+	return dst
+}
+
+// Mode is always 0777, the permissions of a link are not used.
+func (l *Symlink) Mode() os.FileMode {
+	return 0777 | os.ModeSymlink
+}
+
+func (l *Symlink) Type() os.FileMode {
+	return os.ModeSymlink
+}
+
+func (l *Symlink) Owner() (user, group string) {
+	return l.User, l.Group
+}
+
+func (l *Symlink) Open() (io.ReadCloser, error) {
+	return nil, errNotRegular(l.name)
+}
+
+func (l *Symlink) bakeDeepEntries() []string {
+	return nil
+}
+
+func (l *Symlink) chown(user, group string, recursive bool) {
+	if user != "" {
+		l.User = user
+	}
+	if group != "" {
+		l.Group = group
+	}
+}
+
+func (l *Symlink) chmod(mask, mode uint32, recursive bool) {}
+
+func (l *Symlink) touch(t time.Time) {
+	l.ModTime = t
+	l.Touched = true
+}
+
+func (l *Symlink) setXattr(name, value string) {
+	l.Xattrs = withXattr(l.Xattrs, name, value)
+}
+
+func (l *Symlink) tarHeader(path string, w *tarWriter) (*tar.Header, error) {
+	mtime := w.entryModTime(l.ModTime, l.Touched)
+
+	return &tar.Header{
+		Typeflag:   tar.TypeSymlink,
+		Mode:       int64(0777 | c_ISLNK),
+		Name:       path,
+		Linkname:   l.Target,
+		Uname:      l.User,
+		Gname:      l.Group,
+		Uid:        ownerID(l.User),
+		Gid:        ownerID(l.Group),
+		AccessTime: mtime,
+		ChangeTime: mtime,
+		ModTime:    mtime,
+		PAXRecords: xattrRecords(l.Xattrs),
+	}, nil
+}
+
+func (l *Symlink) writeToTar(path string, w *tarWriter) error {
+	h, err := l.tarHeader(path, w)
+	if err != nil {
+		return err
+	}
+
+	return w.writeHeader(h)
+}
@@ -3,84 +3,417 @@ package tarbuild
 import (
 	"archive/tar"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 )
 
-func Build(dst io.Writer, wd, conf string) error {
-	wd, err := filepath.Abs(wd)
+// Option configures a build.
+type Option func(*buildConfig)
+
+type buildConfig struct {
+	transforms  []string
+	mtimeMode   mtimeMode
+	mtime       time.Time
+	format      Format
+	output      Output
+	scanOpts    []ScanOption
+	contextFS   fs.FS
+	ignoreFiles []string
+	compression Compression
+	controlFile string
+	zipMethod   ZipMethod
+	manifest    io.Writer
+	mtree       io.Writer
+	progress    func(Event)
+}
+
+// WithTransform renames the paths in the archive with a sed style
+// s/<regex>/<replacement>/[flags] expression after all the commands of the
+// Tarfile have been applied. See the TRANSFORM command.
+func WithTransform(expr string) Option {
+	return func(c *buildConfig) {
+		c.transforms = append(c.transforms, expr)
+	}
+}
+
+// WithContextXattrs captures the extended attributes of the files and
+// directories in the build context, on Linux only. See CaptureXattrs.
+func WithContextXattrs() Option {
+	return func(c *buildConfig) {
+		c.scanOpts = append(c.scanOpts, CaptureXattrs())
+	}
+}
+
+// WithContextSymlinks keeps the symbolic links of the build context, which
+// are left out otherwise. See CaptureSymlinks.
+func WithContextSymlinks() Option {
+	return func(c *buildConfig) {
+		c.scanOpts = append(c.scanOpts, CaptureSymlinks())
+	}
+}
+
+// WithIgnoreFile removes the entries matched by the ignore files with this
+// name (in the .gitignore format) from the build context. Like ApplyIgnore,
+// the ignore file of a directory is only read when the directories above it
+// have one too.
+func WithIgnoreFile(name string) Option {
+	return func(c *buildConfig) {
+		c.ignoreFiles = append(c.ignoreFiles, name)
+	}
+}
+
+func Build(dst io.Writer, wd, conf string, opts ...Option) error {
+	return BuildContext(context.Background(), dst, wd, conf, opts...)
+}
+
+// BuildContext is like Build but stops with the error of ctx when ctx is
+// done. ctx is checked while the context is scanned, before each command
+// and before each entry of the archive is written.
+func BuildContext(ctx context.Context, dst io.Writer, wd, conf string, opts ...Option) error {
+	r, err := build(ctx, wd, conf, opts)
 	if err != nil {
 		return err
 	}
+	return r.write(dst)
+}
 
-	spec, err := loadTarSpec(conf)
+// BuildSpec is like Build for a Spec made in code rather than a Tarfile.
+func BuildSpec(dst io.Writer, wd string, spec *Spec, opts ...Option) error {
+	return BuildSpecContext(context.Background(), dst, wd, spec, opts...)
+}
+
+// BuildSpecContext is like BuildSpec but stops with the error of ctx when ctx
+// is done, like BuildContext.
+func BuildSpecContext(ctx context.Context, dst io.Writer, wd string, spec *Spec, opts ...Option) error {
+	r, err := buildSpec(ctx, wd, spec, opts)
 	if err != nil {
 		return err
 	}
+	return r.write(dst)
+}
 
-	err = spec.validate()
+// write writes the archive in the format and the compression of the build.
+func (r *result) write(dst io.Writer) error {
+	var (
+		buf bytes.Buffer
+		err error
+	)
+
+	if (r.cfg.manifest != nil || r.manifestPath != "") && r.cfg.output != OutputTar {
+		return errManifest
+	}
+	if len(r.order) > 0 && r.cfg.output != OutputZIP {
+		return errOrder
+	}
+	if !r.deb.empty() {
+		return errDeb
+	}
+	if r.cfg.compression != CompressionNone && r.cfg.output == OutputZIP {
+		return fmt.Errorf("zip archives can't be compressed, their entries are")
+	}
+
+	err = r.startWrite()
 	if err != nil {
 		return err
 	}
 
-	dstFS := NewDir()
-	srcFS, err := NewDirFromOS(wd)
+	switch r.cfg.output {
+	case OutputCPIO:
+		err = r.writeCpio(&buf)
+	case OutputZIP:
+		err = r.writeZip(&buf)
+	default:
+		err = r.writeTar(&buf)
+	}
 	if err != nil {
 		return err
 	}
 
-	for _, op := range spec.Commands {
-		err := applyOp(dstFS, srcFS, op)
+	// The tar archive written for the mtree of other formats isn't
+	// reported.
+	r.writing = nil
+
+	if r.cfg.mtree != nil {
+		archive := buf.Bytes()
+		if r.cfg.output != OutputTar {
+			var tarBuf bytes.Buffer
+			err := r.writeTar(&tarBuf)
+			if err != nil {
+				return err
+			}
+			archive = tarBuf.Bytes()
+		}
+
+		entries, err := readMtreeEntries(bytes.NewReader(archive))
+		if err != nil {
+			return err
+		}
+		err = writeMtree(r.cfg.mtree, entries)
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.cfg.compression == CompressionGzip {
+		data, err := gzipData(buf.Bytes())
 		if err != nil {
 			return err
 		}
+		_, err = dst.Write(data)
+		return err
+	}
+
+	_, err = buf.WriteTo(dst)
+	return err
+}
+
+// result is the tree made by a build, along with what is needed to write it.
+type result struct {
+	ctx           context.Context
+	cfg           buildConfig
+	tree          *Dir
+	segments      []segment
+	deb           debInfo
+	order         []string
+	manifestPath  string
+	mtime         time.Time
+	preserveMtime bool
+
+	// writing counts the entries written by write.
+	writing *writeProgress
+}
+
+func build(ctx context.Context, wd, conf string, opts []Option) (*result, error) {
+	spec, err := loadTarSpec(conf)
+	if err != nil {
+		return nil, err
+	}
+	return buildSpec(ctx, wd, spec, opts)
+}
+
+func buildSpec(ctx context.Context, wd string, spec *Spec, opts []Option) (*result, error) {
+	r := &result{ctx: ctx}
+	for _, opt := range opts {
+		opt(&r.cfg)
+	}
+
+	var err error
+	r.mtime, r.preserveMtime, err = r.cfg.modTime()
+	if err != nil {
+		return nil, err
+	}
+
+	wd, err = filepath.Abs(wd)
+	if err != nil {
+		return nil, err
+	}
+
+	// validate completes the arguments of some commands, so the spec of the
+	// caller is copied.
+	spec = spec.clone()
+
+	if len(r.cfg.transforms) > 0 {
+		spec.Commands = append(spec.Commands, Op{
+			Name: "TRANSFORM",
+			Args: r.cfg.transforms,
+		})
 	}
 
+	err = spec.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	dstFS := NewDir()
+
+	scanOpts := append([]ScanOption{ScanContext(ctx)}, r.cfg.scanOpts...)
+
+	var srcFS *Dir
+	if r.cfg.contextFS != nil {
+		srcFS, err = NewDirFromFS(r.cfg.contextFS, scanOpts...)
+	} else {
+		srcFS, err = NewDirFromOS(wd, scanOpts...)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range r.cfg.ignoreFiles {
+		err := srcFS.ApplyIgnore(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Layers without commands are left out.
 	var (
-		buf bytes.Buffer
-		w   = tar.NewWriter(&buf)
+		layerName string
+		layerOps  int
 	)
+	for i, op := range spec.Commands {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		r.event(Event{Kind: EventOpStarted, Op: op, N: i + 1, Total: len(spec.Commands)})
+
+		switch {
+		case op.Name == "LAYER":
+			if layerOps > 0 {
+				r.segments = append(r.segments, segment{layerName, dstFS.deepCopy()})
+			}
+			layerName, layerOps = "", 0
+			if len(op.Args) > 0 {
+				layerName = op.Args[0]
+			}
+
+		case op.Name == "MANIFEST":
+			err = applyMANIFEST(r, dstFS, op)
+
+		case op.Name == "ORDER":
+			r.order = append(r.order, op.Args...)
+
+		case isDebDirective(op.Name):
+			err = r.deb.apply(srcFS, op)
+
+		case op.plugin != "":
+			err = applyPlugin(ctx, wd, dstFS, op)
+			layerOps++
+
+		default:
+			err = applyOp(dstFS, srcFS, op)
+			layerOps++
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		r.event(Event{Kind: EventOpFinished, Op: op, N: i + 1, Total: len(spec.Commands)})
+	}
+	if layerOps > 0 || len(r.segments) == 0 {
+		r.segments = append(r.segments, segment{layerName, dstFS})
+	}
+
+	r.tree = dstFS
+	return r, nil
+}
+
+func (r *result) newTarWriter(w io.Writer) *tarWriter {
+	return &tarWriter{
+		Writer:          tar.NewWriter(w),
+		modTime:         r.mtime,
+		preserveModTime: r.preserveMtime,
+		format:          r.cfg.format.tarFormat(),
+	}
+}
+
+// writeTar writes the tree as a tar archive, along with its manifest when
+// one is needed.
+func (r *result) writeTar(dst io.Writer) error {
+	w := r.newTarWriter(dst)
+	w.onEntry = r.writeTarEntry
 
-	err = dstFS.writeEntriesToTar("", w)
+	if r.cfg.manifest != nil || r.manifestPath != "" {
+		w.manifest = &Manifest{Entries: []ManifestEntry{}}
+	}
+
+	err := w.checkFormat(r.tree)
 	if err != nil {
 		return err
 	}
 
-	err = w.Close()
+	err = r.tree.writeEntriesToTar("", w)
 	if err != nil {
 		return err
 	}
 
-	_, err = buf.WriteTo(dst)
-	return err
+	if w.manifest != nil {
+		err = r.writeManifest(w)
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.Close()
 }
 
-func applyOp(dst, src *Dir, op tarOp) error {
-	switch op.Name {
-	case "MKDIR":
-		return applyMKDIR(dst, src, op)
-	case "COPY":
-		return applyCOPY(dst, src, op)
-	case "CHMOD":
-		return applyCHMOD(dst, src, op)
-	case "CHOWN":
-		return applyCHOWN(dst, src, op)
-	default:
+var (
+	// errOrder is returned by the writers which don't honour ORDER.
+	errOrder = errors.New("ORDER is only supported for zip archives")
+
+	// errManifest is returned by the writers which don't write manifests.
+	errManifest = errors.New("manifests are only supported for tar archives")
+
+	// errDeb is returned by the writers of the other outputs than Debian
+	// packages when the Tarfile has Debian directives.
+	errDeb = errors.New("CONTROL, CONFFILES and the maintainer scripts are only supported for Debian packages")
+
+	// errMtree is returned by the writers which don't write mtrees.
+	errMtree = errors.New("mtrees are only supported for archives")
+)
+
+// checkTreeOutput returns an error for the directives and options which are
+// only honoured by write, for the packages, images and layers.
+func (r *result) checkTreeOutput() error {
+	switch {
+	case len(r.order) > 0:
+		return errOrder
+	case r.cfg.manifest != nil || r.manifestPath != "":
+		return errManifest
+	case r.cfg.mtree != nil:
+		return errMtree
+	}
+	return nil
+}
+
+// writeTree writes tree as a tar archive.
+func (r *result) writeTree(dst io.Writer, tree *Dir) error {
+	err := r.checkTreeOutput()
+	if err != nil {
+		return err
+	}
+	if !r.deb.empty() {
+		return errDeb
+	}
+
+	w := r.newTarWriter(dst)
+	w.onEntry = r.writeTarEntry
+
+	err = w.checkFormat(tree)
+	if err != nil {
+		return err
+	}
+
+	err = tree.writeEntriesToTar("", w)
+	if err != nil {
+		return err
+	}
+
+	return w.Close()
+}
+
+func applyOp(dst, src *Dir, op Op) error {
+	o, found := lookupOp(op.Name)
+	if !found || o.apply == nil {
 		return fmt.Errorf("unsupported command %q", op.Name)
 	}
+	return o.apply(dst, src, op)
 }
 
-func loadTarSpec(name string) (*tarSpec, error) {
+func loadTarSpec(name string) (*Spec, error) {
 	if name == "-" {
 		return maybeParseTarspec(ioutil.ReadAll(os.Stdin))
 	}
 	return maybeParseTarspec(ioutil.ReadFile(name))
 }
 
-func maybeParseTarspec(data []byte, err error) (*tarSpec, error) {
+func maybeParseTarspec(data []byte, err error) (*Spec, error) {
 	if err == nil {
 		return parseConf(data)
 	}
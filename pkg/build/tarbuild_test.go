@@ -1,8 +1,18 @@
 package tarbuild
 
 import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/fs"
 	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func TestBuild(t *testing.T) {
@@ -11,3 +21,273 @@ func TestBuild(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestBuild_modTime(t *testing.T) {
+	// Headers only keep whole seconds.
+	mtime := time.Date(2019, 5, 18, 10, 0, 0, 0, time.UTC)
+	err := os.Chtimes("testdata/a-dir/a.txt", mtime, mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tarfile := `
+COPY a-dir data
+MKDIR empty
+TOUCH -d 2020-02-02 data/b.txt
+COPY a-dir/a.txt reset/a.txt
+TOUCH reset/a.txt
+`
+
+	tests := []struct {
+		opts     []Option
+		expected map[string]time.Time
+	}{
+		{nil, map[string]time.Time{
+			"data/a.txt":  ftime,
+			"data/b.txt":  time.Date(2020, 2, 2, 0, 0, 0, 0, time.UTC),
+			"empty/":      ftime,
+			"reset/a.txt": ftime,
+		}},
+		{[]Option{WithModTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))}, map[string]time.Time{
+			"data/a.txt":  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			"data/b.txt":  time.Date(2020, 2, 2, 0, 0, 0, 0, time.UTC),
+			"empty/":      time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+			"reset/a.txt": time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		}},
+		{[]Option{WithPreservedModTime()}, map[string]time.Time{
+			"data/a.txt":  mtime,
+			"data/b.txt":  time.Date(2020, 2, 2, 0, 0, 0, 0, time.UTC),
+			"empty/":      ftime,
+			"reset/a.txt": ftime,
+		}},
+	}
+
+	for _, test := range tests {
+		headers := buildHeaders(t, tarfile, test.opts...)
+		for name, expected := range test.expected {
+			h, found := headers[name]
+			if !found {
+				t.Fatalf("missing %q", name)
+			}
+			if !h.ModTime.Equal(expected) {
+				t.Errorf("%s: expected %v but got %v", name, expected, h.ModTime)
+			}
+		}
+	}
+}
+
+// buildHeaders builds tarfile in the testdata context and returns the headers
+// of the archive by name.
+func buildHeaders(t *testing.T, tarfile string, opts ...Option) map[string]*tar.Header {
+	t.Helper()
+
+	var buf bytes.Buffer
+	err := Build(&buf, "testdata", writeTarfile(t, tarfile), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers := map[string]*tar.Header{}
+	r := tar.NewReader(&buf)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		headers[h.Name] = h
+	}
+
+	return headers
+}
+
+func TestBuild_format(t *testing.T) {
+	long := strings.Repeat("x", 300)
+	tarfile := `
+COPY a-dir data
+CHOWN jürgen data/a.txt
+COPY a-dir/b.txt ` + long + `
+`
+
+	var buf bytes.Buffer
+	err := Build(&buf, "testdata", writeTarfile(t, tarfile), WithFormat(FormatUSTAR))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	expected := `the USTAR format can't represent "data/a.txt", "` + long + `"`
+	if err.Error() != expected {
+		t.Fatalf("expected %q but got %q", expected, err)
+	}
+
+	// A PAX header without records reads back as USTAR.
+	allowed := map[Format]tar.Format{
+		FormatPAX: tar.FormatPAX | tar.FormatUSTAR,
+		FormatGNU: tar.FormatGNU,
+	}
+
+	for format, allowedFormats := range allowed {
+		headers := buildHeaders(t, tarfile, WithFormat(format))
+		for _, h := range headers {
+			if h.Format&allowedFormats == 0 {
+				t.Errorf("%s: expected %v but got %v", h.Name, allowedFormats, h.Format)
+			}
+		}
+		if headers["data/a.txt"].Uname != "jürgen" {
+			t.Errorf("%s: unexpected user %q", format, headers["data/a.txt"].Uname)
+		}
+	}
+}
+
+// writeTarfile writes tarfile to a temporary file which is removed when the
+// test finishes.
+func writeTarfile(t *testing.T, tarfile string) string {
+	t.Helper()
+
+	f, err := ioutil.TempFile("", "Tarfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(f.Name()) })
+
+	_, err = f.WriteString(tarfile)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f.Name()
+}
+
+func TestBuild_xattrs(t *testing.T) {
+	tarfile := `
+COPY a-dir data
+XATTR security.selinux 0x73797374656d5f753a6f626a6563745f723a62696e5f743a733000 data/*
+SETCAP cap_net_bind_service+ep data/a.txt data
+`
+
+	headers := buildHeaders(t, tarfile)
+
+	a := headers["data/a.txt"].PAXRecords
+	if v := a["SCHILY.xattr.security.selinux"]; v != "system_u:object_r:bin_t:s0\x00" {
+		t.Errorf("unexpected selinux label %q", v)
+	}
+	expected := "\x01\x00\x00\x02\x00\x04\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00"
+	if v := a["SCHILY.xattr.security.capability"]; v != expected {
+		t.Errorf("expected capabilities %q but got %q", expected, v)
+	}
+
+	b := headers["data/b.txt"].PAXRecords
+	if _, found := b["SCHILY.xattr.security.capability"]; found {
+		t.Errorf("unexpected capabilities on data/b.txt")
+	}
+
+	dir := headers["data/"].PAXRecords
+	if _, found := dir["SCHILY.xattr.security.capability"]; found {
+		t.Errorf("unexpected capabilities on the data directory")
+	}
+}
+
+func TestBuild_nodes(t *testing.T) {
+	tarfile := `
+MKDIR dev
+MKNOD dev/console c 5 1
+MKNOD dev/sda b 8 0
+MKFIFO dev/initctl
+CHMOD 0600 dev/console
+`
+
+	headers := buildHeaders(t, tarfile)
+
+	tests := []struct {
+		name     string
+		typeflag byte
+		major    int64
+		minor    int64
+		mode     int64
+	}{
+		{"dev/console", tar.TypeChar, 5, 1, 0020600},
+		{"dev/sda", tar.TypeBlock, 8, 0, 0060644},
+		{"dev/initctl", tar.TypeFifo, 0, 0, 0010644},
+	}
+
+	for _, test := range tests {
+		h, found := headers[test.name]
+		if !found {
+			t.Fatalf("missing %q", test.name)
+		}
+		if h.Typeflag != test.typeflag || h.Devmajor != test.major || h.Devminor != test.minor || h.Mode != test.mode {
+			t.Errorf("%s: unexpected header %c %d:%d %o", test.name, h.Typeflag, h.Devmajor, h.Devminor, h.Mode)
+		}
+	}
+}
+
+func TestBuild_symlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the context has a symbolic link")
+	}
+
+	wd := t.TempDir()
+	err := os.Mkdir(filepath.Join(wd, "bin"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink("../lib/ld.so", filepath.Join(wd, "bin", "ld.so"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys := fstest.MapFS{
+		"bin":       {Mode: fs.ModeDir | 0755},
+		"bin/ld.so": {Mode: fs.ModeSymlink | 0777, Data: []byte("../lib/ld.so")},
+	}
+
+	conf := writeTarfile(t, "COPY bin /\n")
+	contexts := map[string][]Option{
+		"os": nil,
+		"fs": {WithContextFS(fsys)},
+	}
+	for name, opts := range contexts {
+		// Symbolic links are left out by default.
+		var buf bytes.Buffer
+		err := Build(&buf, wd, conf, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, h := range readHeaders(t, &buf) {
+			if h.Name == "bin/ld.so" {
+				t.Errorf("%s: expected bin/ld.so to be left out", name)
+			}
+		}
+
+		buf.Reset()
+		err = Build(&buf, wd, conf, append(opts, WithContextSymlinks())...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, h := range readHeaders(t, &buf) {
+			if h.Name == "bin/ld.so" {
+				found = true
+				if h.Typeflag != tar.TypeSymlink || h.Linkname != "../lib/ld.so" {
+					t.Errorf("%s: unexpected header %c %q", name, h.Typeflag, h.Linkname)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("%s: missing bin/ld.so", name)
+		}
+	}
+
+	// A file system which can't read links can't keep them.
+	err = Build(io.Discard, "", conf, WithContextFS(noReadLinkFS{fsys}), WithContextSymlinks())
+	if err == nil {
+		t.Error("expected an error for a context without ReadLink")
+	}
+}
+
+// noReadLinkFS hides the ReadLink method of a file system.
+type noReadLinkFS struct {
+	fs.FS
+}
@@ -0,0 +1,126 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// NewDirFromTar loads a tar archive, which may be gzip compressed. The
+// contents of the files are kept in memory and the entries keep the
+// modification times of the archive. Hard links become copies.
+func NewDirFromTar(r io.Reader) (*Dir, error) {
+	zr, err := maybeGunzip(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+
+	rootDir := NewDir()
+
+	tr := tar.NewReader(zr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := path.Join(".", path.Join("/", h.Name))
+		if name == "." {
+			continue
+		}
+
+		// Later entries replace earlier ones, like tar does on extraction.
+		if h.Typeflag != tar.TypeDir {
+			err := rootDir.Remove(name)
+			if err != nil && !os.IsNotExist(err) {
+				return nil, fmt.Errorf("%s: %v", h.Name, err)
+			}
+		}
+
+		e, err := addTarEntry(rootDir, name, h, tr)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", h.Name, err)
+		}
+		if e == nil {
+			continue
+		}
+
+		e.chown(tarOwner(h.Uname, h.Uid), tarOwner(h.Gname, h.Gid), false)
+		e.chmod(07777, uint32(h.Mode&07777), false)
+		e.touch(h.ModTime)
+		for k, v := range h.PAXRecords {
+			if strings.HasPrefix(k, paxXattrPrefix) {
+				e.setXattr(strings.TrimPrefix(k, paxXattrPrefix), v)
+			}
+		}
+	}
+
+	return rootDir, nil
+}
+
+// addTarEntry adds the entry of h to d. Unsupported types are skipped.
+func addTarEntry(d *Dir, name string, h *tar.Header, r io.Reader) (Entry, error) {
+	switch h.Typeflag {
+	case tar.TypeDir:
+		return d.MkdirAll(name)
+
+	case tar.TypeReg:
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		f, err := d.AddFile(name, "")
+		if err != nil {
+			return nil, err
+		}
+		f.Data = data
+		return f, nil
+
+	case tar.TypeLink:
+		target, err := d.GetFile(h.Linkname)
+		if err != nil {
+			return nil, fmt.Errorf("hard link to %s: %v", h.Linkname, err)
+		}
+		data, err := target.readData()
+		if err != nil {
+			return nil, err
+		}
+		f, err := d.AddFile(name, "")
+		if err != nil {
+			return nil, err
+		}
+		f.Data = data
+		return f, nil
+
+	case tar.TypeSymlink:
+		return d.AddSymlink(name, h.Linkname)
+
+	case tar.TypeChar:
+		return d.AddNode(name, CharDevice, h.Devmajor, h.Devminor)
+	case tar.TypeBlock:
+		return d.AddNode(name, BlockDevice, h.Devmajor, h.Devminor)
+	case tar.TypeFifo:
+		return d.AddNode(name, FIFO, 0, 0)
+
+	default:
+		return nil, nil
+	}
+}
+
+// tarOwner returns the name of a user or group, or its id when the archive
+// has no name.
+func tarOwner(name string, id int) string {
+	if name != "" {
+		return name
+	}
+	return strconv.Itoa(id)
+}
@@ -6,64 +6,73 @@ import (
 	"fmt"
 )
 
-type tarSpec struct {
-	Commands []tarOp
+// Spec is a parsed Tarfile: the commands in the order they are applied. The
+// JSON form of a Tarfile is a Spec.
+type Spec struct {
+	Commands []Op
 }
 
-type tarOp struct {
+// Op is a command of a Tarfile, like COPY or CHMOD, with its arguments.
+type Op struct {
 	Name string
 	Args []string
+
+	// plugin is the path of the executable implementing the command, set by
+	// validate when the command isn't registered.
+	plugin string
 }
 
-func (s *tarSpec) validate() error {
-	for _, op := range s.Commands {
-		if err := op.validate(); err != nil {
-			return err
-		}
-	}
-	return nil
+// ParseSpec parses a Tarfile in the text or the JSON format.
+func ParseSpec(data []byte) (*Spec, error) {
+	// parseConf reuses the buffer.
+	return parseConf(append([]byte(nil), data...))
 }
 
-func (op *tarOp) validate() error {
-	if op.Name == "COPY" {
-		if len(op.Args) == 0 {
-			return fmt.Errorf("invalid command: %q requires arguments", op.Name)
-		}
-		if len(op.Args) == 1 {
-			op.Args = append(op.Args, op.Args[0])
-		}
-		return nil
+func (s *Spec) clone() *Spec {
+	dst := &Spec{}
+	if s == nil {
+		return dst
 	}
+	for _, op := range s.Commands {
+		dst.Commands = append(dst.Commands, Op{
+			Name:   op.Name,
+			Args:   append([]string(nil), op.Args...),
+			plugin: op.plugin,
+		})
+	}
+	return dst
+}
 
-	if op.Name == "MKDIR" {
-		if len(op.Args) == 0 {
-			return fmt.Errorf("invalid command: %q requires arguments", op.Name)
+func (s *Spec) validate() error {
+	for i := range s.Commands {
+		if err := s.Commands[i].validate(); err != nil {
+			return err
 		}
-		return nil
 	}
+	return nil
+}
 
-	if op.Name == "CHMOD" {
-		if len(op.Args) == 0 {
-			return fmt.Errorf("invalid command: %q requires arguments", op.Name)
+func (op *Op) validate() error {
+	o, found := lookupOp(op.Name)
+	if !found {
+		file, found := lookupPlugin(op.Name)
+		if !found {
+			return fmt.Errorf("invalid command %q", op.Name)
 		}
+		op.plugin = file
 		return nil
 	}
-
-	if op.Name == "CHOWN" {
-		if len(op.Args) == 0 {
-			return fmt.Errorf("invalid command: %q requires arguments", op.Name)
-		}
+	if o.validate == nil {
 		return nil
 	}
-
-	return fmt.Errorf("invalid command %q", op.Name)
+	return o.validate(op)
 }
 
-func parseConf(data []byte) (*tarSpec, error) {
+func parseConf(data []byte) (*Spec, error) {
 	data = bytes.TrimSpace(data)
 
 	if len(data) > 0 && data[0] == '{' {
-		var spec *tarSpec
+		var spec *Spec
 		err := json.Unmarshal(data, &spec)
 		if err != nil {
 			return nil, fmt.Errorf("invalid spec: %q (%v)", data, err)
@@ -74,7 +83,7 @@ func parseConf(data []byte) (*tarSpec, error) {
 	return parseTextConf(data)
 }
 
-func parseTextConf(data []byte) (*tarSpec, error) {
+func parseTextConf(data []byte) (*Spec, error) {
 	var buf bytes.Buffer
 
 	for inComment, idx := false, 0; idx < len(data); idx++ {
@@ -114,7 +123,7 @@ func parseTextConf(data []byte) (*tarSpec, error) {
 	data = append(data[:0], buf.Bytes()...)
 	buf.Reset()
 
-	spec := &tarSpec{}
+	spec := &Spec{}
 
 	lines := bytes.Split(data, []byte{'\n'})
 	for _, line := range lines {
@@ -123,9 +132,11 @@ func parseTextConf(data []byte) (*tarSpec, error) {
 			continue
 		}
 
+		// Commands without arguments, like LAYER, are rejected by validate
+		// when they require some.
 		idx := bytes.IndexByte(line, ' ')
 		if idx < 0 {
-			return nil, fmt.Errorf("invalid command: %q", line)
+			idx = len(line)
 		}
 
 		var (
@@ -133,7 +144,7 @@ func parseTextConf(data []byte) (*tarSpec, error) {
 			args    = bytes.TrimSpace(line[idx:])
 			argVals []string
 		)
-		if len(cmd) == 0 || len(args) == 0 {
+		if len(cmd) == 0 {
 			return nil, fmt.Errorf("invalid command: %q", line)
 		}
 		for _, c := range cmd {
@@ -142,7 +153,7 @@ func parseTextConf(data []byte) (*tarSpec, error) {
 			}
 			return nil, fmt.Errorf("invalid command: %q", line)
 		}
-		if args[0] == '[' {
+		if len(args) > 0 && args[0] == '[' {
 			err := json.Unmarshal(args, &argVals)
 			if err != nil {
 				return nil, fmt.Errorf("invalid command: %q (%v)", line, err)
@@ -158,7 +169,7 @@ func parseTextConf(data []byte) (*tarSpec, error) {
 			}
 		}
 
-		spec.Commands = append(spec.Commands, tarOp{
+		spec.Commands = append(spec.Commands, Op{
 			Name: string(cmd),
 			Args: argVals,
 		})
@@ -31,8 +31,8 @@ COMMAND [
 		t.Fatal(err)
 	}
 
-	expected := &tarSpec{
-		Commands: []tarOp{
+	expected := &Spec{
+		Commands: []Op{
 			{Name: "COMMAND", Args: []string{"arg1", "arg2", "arg3"}},
 			{Name: "COMMAND", Args: []string{"arg1", "arg2", "arg3"}},
 			{Name: "COMMAND", Args: []string{"arg1", "arg2", "arg3"}},
@@ -0,0 +1,132 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type tarWriter struct {
+	*tar.Writer
+
+	// modTime is used for entries which were not touched. When preserveModTime
+	// is set the modification time from the context is used instead, if there
+	// is one.
+	modTime         time.Time
+	preserveModTime bool
+
+	// format is the header format of all entries. When it is
+	// tar.FormatUnknown archive/tar picks a format for each header.
+	format tar.Format
+
+	// prefix is prepended to the names of all entries.
+	prefix string
+
+	// manifest records the entries which are written, when it is set. digest
+	// hashes the contents of the current file.
+	manifest *Manifest
+	digest   hash.Hash
+
+	// onEntry is called before each entry is written, when it is set.
+	onEntry func(h *tar.Header) error
+}
+
+func (w *tarWriter) entryModTime(t time.Time, touched bool) time.Time {
+	switch {
+	case touched && t.IsZero():
+		// TOUCH without a time uses the time of the build.
+		return w.modTime
+	case touched || (w.preserveModTime && !t.IsZero()):
+		return t
+	}
+	return w.modTime
+}
+
+func (w *tarWriter) writeHeader(h *tar.Header) error {
+	if w.onEntry != nil {
+		err := w.onEntry(h)
+		if err != nil {
+			return err
+		}
+	}
+
+	h = w.formatHeader(h)
+	if w.manifest != nil {
+		w.recordEntry(h)
+	}
+	return w.WriteHeader(h)
+}
+
+func (w *tarWriter) formatHeader(h *tar.Header) *tar.Header {
+	h.Name = w.prefix + h.Name
+
+	if w.format == tar.FormatUnknown {
+		return h
+	}
+
+	h.Format = w.format
+
+	// Only GNU headers have fields for the access and change times; USTAR
+	// can't store them and PAX would need extra records for every entry.
+	if w.format != tar.FormatGNU {
+		h.AccessTime = time.Time{}
+		h.ChangeTime = time.Time{}
+	}
+
+	return h
+}
+
+// checkFormat returns an error listing all entries of d which can't be
+// represented in the format of w.
+func (w *tarWriter) checkFormat(d *Dir) error {
+	if w.format == tar.FormatUnknown {
+		return nil
+	}
+
+	var invalid []string
+
+	err := d.walk("", func(path string, e Entry) error {
+		h, err := e.tarHeader(path, w)
+		if err != nil {
+			return err
+		}
+
+		err = tar.NewWriter(ioutil.Discard).WriteHeader(w.formatHeader(h))
+		if err != nil {
+			invalid = append(invalid, fmt.Sprintf("%q", path))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(invalid) > 0 {
+		return fmt.Errorf("the %s format can't represent %s", w.format, strings.Join(invalid, ", "))
+	}
+	return nil
+}
+
+// parseOwnerID returns the numeric id of a user or group given by its id. root
+// and an empty name are 0. Other names are only stored as names.
+func parseOwnerID(name string) (int, bool) {
+	if name == "" || name == "root" {
+		return 0, true
+	}
+
+	id, err := strconv.Atoi(name)
+	if err != nil || id < 0 {
+		return 0, false
+	}
+	return id, true
+}
+
+// ownerID returns the id of a user or group for tar headers, 0 for names.
+func ownerID(name string) int {
+	id, _ := parseOwnerID(name)
+	return id
+}
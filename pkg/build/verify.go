@@ -0,0 +1,295 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// VerifyReproducible builds the Tarfile twice and compares the archives. The
+// second build scans the context in reverse order and reads the times of the
+// context in another time zone. When the archives differ the error describes
+// the first difference.
+func VerifyReproducible(wd, conf string, opts ...Option) error {
+	return VerifyReproducibleContext(context.Background(), wd, conf, opts...)
+}
+
+// VerifyReproducibleContext is like VerifyReproducible but stops with the
+// error of ctx when ctx is done, like BuildContext.
+func VerifyReproducibleContext(ctx context.Context, wd, conf string, opts ...Option) error {
+	var cfg buildConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	// Nothing is written, the manifest would be empty.
+	if cfg.manifest != nil || cfg.mtree != nil {
+		return fmt.Errorf("manifests and mtrees are not written while verifying a build")
+	}
+
+	var first, second bytes.Buffer
+
+	err := BuildContext(ctx, &first, wd, conf, opts...)
+	if err != nil {
+		return err
+	}
+
+	opts = append(opts[:len(opts):len(opts)], func(c *buildConfig) {
+		c.scanOpts = append(c.scanOpts, func(c *scanConfig) {
+			c.reverse = true
+			c.location = time.FixedZone("UTC+13:45", (13*60+45)*60)
+		})
+	})
+
+	err = BuildContext(ctx, &second, wd, conf, opts...)
+	if err != nil {
+		return err
+	}
+
+	return compareArchives(first.Bytes(), second.Bytes(), cfg.output)
+}
+
+func compareArchives(a, b []byte, output Output) error {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+
+	var err error
+	switch output {
+	case OutputTar:
+		err = compareTars(a, b)
+	case OutputCPIO:
+		err = compareCpios(a, b)
+	case OutputZIP:
+		err = compareZips(a, b)
+	}
+	if err != nil {
+		return err
+	}
+
+	// The entries are the same, the difference is in the framing.
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return fmt.Errorf("the builds are not reproducible: they differ at byte %d", i)
+}
+
+// compareTars returns an error for the first entry which differs in a and b.
+func compareTars(a, b []byte) error {
+	ra := tar.NewReader(bytes.NewReader(a))
+	rb := tar.NewReader(bytes.NewReader(b))
+
+	for {
+		ha, errA := ra.Next()
+		hb, errB := rb.Next()
+		if errA == io.EOF && errB == io.EOF {
+			return nil
+		}
+		if errA != nil || errB != nil {
+			var nameA, nameB string
+			if ha != nil {
+				nameA = ha.Name
+			}
+			if hb != nil {
+				nameB = hb.Name
+			}
+			return fmt.Errorf("the builds are not reproducible: %s", describeEntries(nameA, nameB, errA, errB))
+		}
+
+		if diff := headerDiff(ha, hb); diff != "" {
+			return fmt.Errorf("the builds are not reproducible: %s: %s", ha.Name, diff)
+		}
+
+		da, errA := ioutil.ReadAll(ra)
+		db, errB := ioutil.ReadAll(rb)
+		if errA != nil {
+			return errA
+		}
+		if errB != nil {
+			return errB
+		}
+		if !bytes.Equal(da, db) {
+			return fmt.Errorf("the builds are not reproducible: %s: the contents differ", ha.Name)
+		}
+	}
+}
+
+// describeEntries describes why the entries read from two archives don't
+// match, when reading one of them failed or ended.
+func describeEntries(nameA, nameB string, errA, errB error) string {
+	switch {
+	case errA != nil && errA != io.EOF:
+		return errA.Error()
+	case errB != nil && errB != io.EOF:
+		return errB.Error()
+	case errA == io.EOF:
+		return fmt.Sprintf("the second build has an extra entry %s", nameB)
+	default:
+		return fmt.Sprintf("the first build has an extra entry %s", nameA)
+	}
+}
+
+// compareCpios returns an error for the first entry which differs in a and
+// b, two newc archives.
+func compareCpios(a, b []byte) error {
+	ea, errA := readCpioRecords(a)
+	eb, errB := readCpioRecords(b)
+	if errA != nil {
+		return errA
+	}
+	if errB != nil {
+		return errB
+	}
+
+	for i := 0; i < len(ea) || i < len(eb); i++ {
+		if i >= len(ea) {
+			return fmt.Errorf("the builds are not reproducible: %s", describeEntries("", eb[i].name, io.EOF, nil))
+		}
+		if i >= len(eb) {
+			return fmt.Errorf("the builds are not reproducible: %s", describeEntries(ea[i].name, "", nil, io.EOF))
+		}
+
+		ra, rb := ea[i], eb[i]
+		if ra.name != rb.name {
+			return fmt.Errorf("the builds are not reproducible: %s: Name %s != %s", ra.name, ra.name, rb.name)
+		}
+		for j := range ra.fields {
+			if ra.fields[j] != rb.fields[j] {
+				return fmt.Errorf("the builds are not reproducible: %s: %s %d != %d", ra.name, cpioFields[j], ra.fields[j], rb.fields[j])
+			}
+		}
+		if !bytes.Equal(ra.data, rb.data) {
+			return fmt.Errorf("the builds are not reproducible: %s: the contents differ", ra.name)
+		}
+	}
+	return nil
+}
+
+// cpioFields are the names of the numeric fields of a newc header.
+var cpioFields = []string{"ino", "mode", "uid", "gid", "nlink", "mtime", "filesize", "devmajor", "devminor", "rdevmajor", "rdevminor", "namesize", "check"}
+
+// cpioRecord is an entry read from a newc archive.
+type cpioRecord struct {
+	name   string
+	fields []int64
+	data   []byte
+}
+
+// readCpioRecords reads the entries of a newc archive, up to its trailer.
+func readCpioRecords(data []byte) ([]cpioRecord, error) {
+	var (
+		records []cpioRecord
+		off     int64
+	)
+	for {
+		if int64(len(data)) < off+110 || string(data[off:off+6]) != "070701" {
+			return nil, fmt.Errorf("invalid cpio header at byte %d", off)
+		}
+
+		r := cpioRecord{fields: make([]int64, len(cpioFields))}
+		for i := range r.fields {
+			v, err := strconv.ParseUint(string(data[off+6+8*int64(i):off+14+8*int64(i)]), 16, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cpio header at byte %d", off)
+			}
+			r.fields[i] = int64(v)
+		}
+
+		size, nameSize := r.fields[6], r.fields[11]
+		start := (off + 110 + nameSize + 3) &^ 3
+		if nameSize == 0 || int64(len(data)) < start+size {
+			return nil, fmt.Errorf("truncated cpio entry at byte %d", off)
+		}
+		r.name = string(data[off+110 : off+110+nameSize-1])
+		if r.name == cpioTrailer {
+			return records, nil
+		}
+		r.data = data[start : start+size]
+
+		records = append(records, r)
+		off = (start + size + 3) &^ 3
+	}
+}
+
+// compareZips returns an error for the first entry which differs in a and b.
+func compareZips(a, b []byte) error {
+	za, err := zip.NewReader(bytes.NewReader(a), int64(len(a)))
+	if err != nil {
+		return err
+	}
+	zb, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(za.File) || i < len(zb.File); i++ {
+		if i >= len(za.File) {
+			return fmt.Errorf("the builds are not reproducible: %s", describeEntries("", zb.File[i].Name, io.EOF, nil))
+		}
+		if i >= len(zb.File) {
+			return fmt.Errorf("the builds are not reproducible: %s", describeEntries(za.File[i].Name, "", nil, io.EOF))
+		}
+
+		fa, fb := za.File[i], zb.File[i]
+		if diff := headerDiff(fa.FileHeader, fb.FileHeader); diff != "" {
+			return fmt.Errorf("the builds are not reproducible: %s: %s", fa.Name, diff)
+		}
+
+		da, err := readZipFile(fa)
+		if err != nil {
+			return err
+		}
+		db, err := readZipFile(fb)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(da, db) {
+			return fmt.Errorf("the builds are not reproducible: %s: the contents differ", fa.Name)
+		}
+	}
+	return nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// headerDiff describes the first field which differs in a and b, two
+// headers of the same type.
+func headerDiff(a, b interface{}) string {
+	va, vb := reflect.Indirect(reflect.ValueOf(a)), reflect.Indirect(reflect.ValueOf(b))
+
+	for i := 0; i < va.NumField(); i++ {
+		if va.Type().Field(i).PkgPath != "" {
+			continue
+		}
+
+		fa, fb := va.Field(i).Interface(), vb.Field(i).Interface()
+
+		if ta, ok := fa.(time.Time); ok {
+			if !ta.Equal(fb.(time.Time)) {
+				return fmt.Sprintf("%s %v != %v", va.Type().Field(i).Name, fa, fb)
+			}
+			continue
+		}
+
+		if !reflect.DeepEqual(fa, fb) {
+			return fmt.Sprintf("%s %v != %v", va.Type().Field(i).Name, fa, fb)
+		}
+	}
+
+	return ""
+}
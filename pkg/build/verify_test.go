@@ -0,0 +1,89 @@
+package tarbuild
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyReproducible(t *testing.T) {
+	for _, output := range []Output{OutputTar, OutputCPIO, OutputZIP} {
+		err := VerifyReproducible("testdata", "testdata/Tarfile", WithOutput(output))
+		if err != nil {
+			t.Errorf("%q: %v", output, err)
+		}
+
+		// The second build reads the times of the context in another zone.
+		err = VerifyReproducible("testdata", "testdata/Tarfile", WithOutput(output), WithPreservedModTime())
+		if err != nil {
+			t.Errorf("%q with preserved times: %v", output, err)
+		}
+	}
+}
+
+func TestCompareArchives(t *testing.T) {
+	tests := map[Output]string{
+		OutputTar:  "the builds are not reproducible: env/: ModTime ",
+		OutputCPIO: "the builds are not reproducible: env: mtime ",
+		OutputZIP:  "the builds are not reproducible: env/: Modified ",
+	}
+
+	for output, expected := range tests {
+		var a, b bytes.Buffer
+
+		err := Build(&a, "testdata", "testdata/Tarfile", WithOutput(output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = Build(&b, "testdata", "testdata/Tarfile", WithOutput(output), WithModTime(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = compareArchives(a.Bytes(), b.Bytes(), output)
+		if err == nil {
+			t.Errorf("%q: expected an error", output)
+			continue
+		}
+		if got := err.Error(); !strings.HasPrefix(got, expected) {
+			t.Errorf("%q: expected %q... but got %q", output, expected, got)
+		}
+	}
+}
+
+func TestCompareArchives_extraEntry(t *testing.T) {
+	short, err := ParseSpec([]byte(`{"Commands": [{"Name": "MKDIR", "Args": ["a"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	long, err := ParseSpec([]byte(`{"Commands": [{"Name": "MKDIR", "Args": ["a"]}, {"Name": "MKDIR", "Args": ["b"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, output := range []Output{OutputTar, OutputCPIO, OutputZIP} {
+		var a, b bytes.Buffer
+
+		err := BuildSpec(&a, "testdata", short, WithOutput(output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = BuildSpec(&b, "testdata", long, WithOutput(output))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := "the builds are not reproducible: the second build has an extra entry b"
+		err = compareArchives(a.Bytes(), b.Bytes(), output)
+		if err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("%q: expected %q but got %v", output, expected, err)
+		}
+
+		expected = "the builds are not reproducible: the first build has an extra entry b"
+		err = compareArchives(b.Bytes(), a.Bytes(), output)
+		if err == nil || !strings.HasPrefix(err.Error(), expected) {
+			t.Errorf("%q: expected %q but got %v", output, expected, err)
+		}
+	}
+}
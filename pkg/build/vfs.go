@@ -3,6 +3,10 @@ package tarbuild
 import (
 	"archive/tar"
 	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path"
@@ -10,20 +14,82 @@ import (
 	"sort"
 	"strings"
 	"time"
-
-	"github.com/sabhiram/go-git-ignore"
 )
 
 func NewDir() *Dir {
 	return &Dir{
-		Name:  "/",
+		name:  "/",
 		Perm:  0755,
 		User:  "root",
 		Group: "root",
 	}
 }
 
-func NewDirFromOS(root string) (*Dir, error) {
+// ScanOption configures how NewDirFromOS reads the context.
+type ScanOption func(*scanConfig)
+
+type scanConfig struct {
+	xattrs   bool
+	symlinks bool
+
+	// ctx interrupts the scan when it is done.
+	ctx context.Context
+
+	// reverse adds the entries in the reverse order of the walk, to check
+	// the result doesn't depend on the order.
+	reverse bool
+
+	// location is the time zone of the times of the entries, when set.
+	location *time.Location
+}
+
+// CaptureXattrs makes NewDirFromOS read the extended attributes of the files
+// and directories in the context. Attributes are only read on Linux; on other
+// systems the option has no effect.
+func CaptureXattrs() ScanOption {
+	return func(c *scanConfig) {
+		c.xattrs = true
+	}
+}
+
+// CaptureSymlinks keeps the symbolic links of the context in the tree.
+// Without it they are left out. NewDirFromFS requires a file system with a
+// ReadLink method.
+func CaptureSymlinks() ScanOption {
+	return func(c *scanConfig) {
+		c.symlinks = true
+	}
+}
+
+// ScanContext stops the scan with the error of ctx when ctx is done.
+func ScanContext(ctx context.Context) ScanOption {
+	return func(c *scanConfig) {
+		c.ctx = ctx
+	}
+}
+
+// err returns the error of the context of the scan, if any.
+func (c *scanConfig) err() error {
+	if c.ctx == nil {
+		return nil
+	}
+	return c.ctx.Err()
+}
+
+// modTime returns the modification time of fi in the time zone of the scan.
+func (c *scanConfig) modTime(fi os.FileInfo) time.Time {
+	if c.location == nil {
+		return fi.ModTime()
+	}
+	return fi.ModTime().In(c.location)
+}
+
+func NewDirFromOS(root string, opts ...ScanOption) (*Dir, error) {
+	var cfg scanConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
 	rootDir := NewDir()
 
 	root, err := filepath.Abs(root)
@@ -31,14 +97,18 @@ func NewDirFromOS(root string) (*Dir, error) {
 		return nil, err
 	}
 
-	err = filepath.Walk(root, func(name string, fi os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if root == name {
-			return nil
+	// add adds an entry of the context; name is relative to root.
+	add := func(name string, fi os.FileInfo) error {
+		var (
+			xattrs map[string]string
+			err    error
+		)
+		if cfg.xattrs && (fi.IsDir() || fi.Mode().IsRegular()) {
+			xattrs, err = readXattrs(path.Join(root, name))
+			if err != nil {
+				return err
+			}
 		}
-		name = strings.TrimPrefix(name, root)
 
 		if fi.IsDir() {
 			dir, err := rootDir.MkdirAll(name)
@@ -47,6 +117,8 @@ func NewDirFromOS(root string) (*Dir, error) {
 			}
 
 			dir.Perm = fi.Mode().Perm()
+			dir.ModTime = cfg.modTime(fi)
+			dir.Xattrs = xattrs
 		}
 
 		if fi.Mode().IsRegular() {
@@ -56,44 +128,123 @@ func NewDirFromOS(root string) (*Dir, error) {
 			}
 
 			file.Perm = fi.Mode().Perm()
+			file.ModTime = cfg.modTime(fi)
+			file.Xattrs = xattrs
+		}
+
+		if cfg.symlinks && fi.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(path.Join(root, name))
+			if err != nil {
+				return err
+			}
+
+			link, err := rootDir.AddSymlink(name, target)
+			if err != nil {
+				return err
+			}
+
+			link.ModTime = cfg.modTime(fi)
 		}
 
 		return nil
+	}
+
+	var reversed []func() error
+
+	err = filepath.Walk(root, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := cfg.err(); err != nil {
+			return err
+		}
+		if root == name {
+			return nil
+		}
+		name = strings.TrimPrefix(name, root)
+
+		if cfg.reverse {
+			reversed = append(reversed, func() error { return add(name, fi) })
+			return nil
+		}
+		return add(name, fi)
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	rootDir.BakeDeepEntries()
+	for i := len(reversed) - 1; i >= 0; i-- {
+		err := reversed[i]()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return rootDir, nil
 }
 
+// Entry is a file, directory, symlink or device node of a tree. The
+// unexported methods keep the set of entry types closed.
 type Entry interface {
+	// Name returns the base name of the entry.
+	Name() string
+
+	// Mode returns the permission and type bits of the entry.
+	Mode() os.FileMode
+
+	// Owner returns the user and group names of the entry.
+	Owner() (user, group string)
+
+	// Type returns the type bits of the entry, like fs.DirEntry.
+	Type() os.FileMode
+
+	// Open returns the contents of a file. Other entries can't be opened.
+	Open() (io.ReadCloser, error)
+
 	isDir() bool
-	name() string
-	mode() os.FileMode
+	clone(name string) Entry
 	bakeDeepEntries() []string
-	applyIgnore(ignoreFileName string) error
 	chown(user, group string, recursive bool)
 	chmod(mask, mode uint32, recursive bool)
-	writeToTar(path string, w *tar.Writer) error
+	touch(t time.Time)
+	setXattr(name, value string)
+	tarHeader(path string, w *tarWriter) (*tar.Header, error)
+	writeToTar(path string, w *tarWriter) error
 }
 
 type Dir struct {
-	Name        string
-	Perm        os.FileMode
-	User        string
-	Group       string
-	Entries     []Entry
+	name    string
+	Perm    os.FileMode
+	User    string
+	Group   string
+	ModTime time.Time
+	Touched bool
+	Xattrs  map[string]string
+	Entries []Entry
+
+	// DeepEntries holds the sorted paths of all the entries below the
+	// directory. The methods of Dir keep it up to date; it only has to be
+	// rebuilt with BakeDeepEntries after changing Entries directly.
 	DeepEntries []string
 }
 
 type File struct {
-	Name         string
+	name         string
 	Perm         os.FileMode
 	User         string
 	Group        string
+	ModTime      time.Time
+	Touched      bool
+	Xattrs       map[string]string
 	OriginalName string
+
+	// Data holds the contents of files which don't come from the context,
+	// those without an OriginalName.
+	Data []byte
+
+	// fsys is the context the file comes from when it isn't the host file
+	// system. OriginalName is a path in fsys then.
+	fsys fs.FS
 }
 
 func (d *Dir) Add(name string, entry Entry) (Entry, error) {
@@ -101,24 +252,24 @@ func (d *Dir) Add(name string, entry Entry) (Entry, error) {
 
 	dirName, fileName := path.Split(name)
 	dirName = path.Join(".", path.Join("/", dirName))
-	d, err := d.MkdirAll(dirName)
+	parent, err := d.MkdirAll(dirName)
 	if err != nil {
 		return nil, err
 	}
 
-	e, err := d.GetEntry(fileName)
+	e, err := parent.GetEntry(fileName)
 	if err == nil {
 		if e.isDir() {
-			return e.(*Dir).Add(entry.name(), entry)
+			return d.Add(path.Join(name, entry.Name()), entry)
 		}
 		if entry.isDir() {
 			return nil, os.ErrExist
 		}
-		err := d.Remove(fileName)
+		err := d.Remove(name)
 		if err != nil {
 			return nil, err
 		}
-		return d.Add(fileName, entry)
+		return d.Add(name, entry)
 	}
 	if os.IsNotExist(err) {
 		err = nil
@@ -127,25 +278,8 @@ func (d *Dir) Add(name string, entry Entry) (Entry, error) {
 		return nil, err
 	}
 
-	if entry.isDir() {
-		src := entry.(*Dir)
-		dst := &Dir{}
-		*dst = *src
-		dst.Entries = nil
-		dst.DeepEntries = nil
-		dst.Entries = append(dst.Entries, src.Entries...)
-		dst.Name = fileName
-		d.Entries = append(d.Entries, dst)
-		sort.Sort(d)
-		return dst, nil
-	}
-
-	src := entry.(*File)
-	dst := &File{}
-	*dst = *src
-	dst.Name = fileName
-	d.Entries = append(d.Entries, dst)
-	sort.Sort(d)
+	dst := entry.clone(fileName)
+	d.insert(name, parent, dst)
 	return dst, nil
 }
 
@@ -154,12 +288,12 @@ func (d *Dir) AddFile(name, original string) (*File, error) {
 
 	dirName, fileName := path.Split(name)
 	dirName = path.Join(".", path.Join("/", dirName))
-	d, err := d.MkdirAll(dirName)
+	parent, err := d.MkdirAll(dirName)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = d.GetEntry(fileName)
+	_, err = parent.GetEntry(fileName)
 	if err == nil {
 		err = os.ErrExist
 	}
@@ -171,15 +305,14 @@ func (d *Dir) AddFile(name, original string) (*File, error) {
 	}
 
 	file := &File{
-		Name:         fileName,
+		name:         fileName,
 		Perm:         0644,
 		User:         "root",
 		Group:        "root",
 		OriginalName: original,
 	}
 
-	d.Entries = append(d.Entries, file)
-	sort.Sort(d)
+	d.insert(name, parent, file)
 	return file, nil
 }
 
@@ -190,18 +323,14 @@ func (d *Dir) Mkdir(name string) (*Dir, error) {
 		return nil, os.ErrExist
 	}
 
-	idx := strings.IndexRune(name, '/')
-	if idx >= 0 {
-		dirName, baseName := path.Split(name)
-		dirName = path.Join(".", path.Join("/", dirName))
-		dir, err := d.GetDir(dirName)
-		if err != nil {
-			return nil, err
-		}
-		return dir.Mkdir(baseName)
+	dirName, baseName := path.Split(name)
+	dirName = path.Join(".", path.Join("/", dirName))
+	parent, err := d.GetDir(dirName)
+	if err != nil {
+		return nil, err
 	}
 
-	_, err := d.GetEntry(name)
+	_, err = parent.GetEntry(baseName)
 	if err == nil {
 		err = os.ErrExist
 	}
@@ -213,14 +342,13 @@ func (d *Dir) Mkdir(name string) (*Dir, error) {
 	}
 
 	dir := &Dir{
-		Name:  name,
+		name:  baseName,
 		Perm:  0755,
 		User:  "root",
 		Group: "root",
 	}
 
-	d.Entries = append(d.Entries, dir)
-	sort.Sort(d)
+	d.insert(name, parent, dir)
 	return dir, nil
 }
 
@@ -235,14 +363,14 @@ func (d *Dir) MkdirAll(name string) (*Dir, error) {
 		return nil, err
 	}
 
-	dirName, fileName := path.Split(name)
+	dirName, _ := path.Split(name)
 	dirName = path.Join(".", path.Join("/", dirName))
-	d, err = d.MkdirAll(dirName)
+	_, err = d.MkdirAll(dirName)
 	if err != nil {
 		return nil, err
 	}
 
-	return d.Mkdir(fileName)
+	return d.Mkdir(name)
 }
 
 func (d *Dir) Remove(name string) error {
@@ -250,28 +378,90 @@ func (d *Dir) Remove(name string) error {
 
 	dirName, eName := path.Split(name)
 	dirName = path.Join(".", path.Join("/", dirName))
-	if dirName != "." {
-
-		dir, err := d.GetDir(dirName)
-		if err != nil {
-			return err
-		}
-		return dir.Remove(eName)
+	parent, err := d.GetDir(dirName)
+	if err != nil {
+		return err
 	}
 
-	for i, e := range d.Entries {
-		if e.name() != eName {
+	for i, e := range parent.Entries {
+		if e.Name() != eName {
 			continue
 		}
 
-		copy(d.Entries[i:], d.Entries[i+1:])
-		d.Entries = d.Entries[:len(d.Entries)-1]
+		copy(parent.Entries[i:], parent.Entries[i+1:])
+		parent.Entries = parent.Entries[:len(parent.Entries)-1]
+
+		d.eachParent(name, func(dir *Dir, rel string) {
+			dir.DeepEntries = removeDeepEntries(dir.DeepEntries, rel)
+		})
 		return nil
 	}
 
 	return os.ErrNotExist
 }
 
+// insert adds e to parent, the directory of name below d, and records it in
+// the DeepEntries of d and of the directories in between.
+func (d *Dir) insert(name string, parent *Dir, e Entry) {
+	parent.Entries = append(parent.Entries, e)
+	sort.Sort(parent)
+
+	var sub []string
+	if dir, ok := e.(*Dir); ok {
+		sub = dir.DeepEntries
+	}
+
+	d.eachParent(name, func(dir *Dir, rel string) {
+		added := make([]string, 0, len(sub)+1)
+		added = append(added, rel)
+		for _, n := range sub {
+			added = append(added, rel+"/"+n)
+		}
+		dir.DeepEntries = mergeDeepEntries(dir.DeepEntries, added)
+	})
+}
+
+// eachParent calls fn for d and every directory below d on the way to name,
+// with name relative to that directory.
+func (d *Dir) eachParent(name string, fn func(dir *Dir, rel string)) {
+	for dir := d; dir != nil; {
+		fn(dir, name)
+
+		idx := strings.IndexByte(name, '/')
+		if idx < 0 {
+			return
+		}
+		dir, _ = dir.GetDir(name[:idx])
+		name = name[idx+1:]
+	}
+}
+
+// mergeDeepEntries merges two sorted lists of paths into a new one.
+func mergeDeepEntries(a, b []string) []string {
+	out := make([]string, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if a[0] < b[0] {
+			out, a = append(out, a[0]), a[1:]
+		} else {
+			out, b = append(out, b[0]), b[1:]
+		}
+	}
+	out = append(out, a...)
+	return append(out, b...)
+}
+
+// removeDeepEntries returns a copy of deep without name and the paths below
+// it.
+func removeDeepEntries(deep []string, name string) []string {
+	out := make([]string, 0, len(deep))
+	for _, n := range deep {
+		if n != name && !strings.HasPrefix(n, name+"/") {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
 func (d *Dir) GetDir(name string) (*Dir, error) {
 	e, err := d.GetEntry(name)
 	if err != nil {
@@ -296,12 +486,11 @@ func (d *Dir) GetFile(name string) (*File, error) {
 	return f, nil
 }
 
+// ReadFile returns a copy of the contents of the file at name, following
+// symlinks. Like GetEntry, name is relative to d even when it starts with a
+// "/". Device nodes and FIFOs read as empty files.
 func (d *Dir) ReadFile(name string) ([]byte, error) {
-	f, err := d.GetFile(name)
-	if err != nil {
-		return nil, err
-	}
-	return ioutil.ReadFile(f.OriginalName)
+	return dirFS{d}.ReadFile(path.Join(".", path.Join("/", name)))
 }
 
 func (d *Dir) GetEntry(name string) (Entry, error) {
@@ -319,7 +508,7 @@ func (d *Dir) GetEntry(name string) (Entry, error) {
 	}
 
 	for _, e := range d.Entries {
-		if e.name() == name {
+		if e.Name() == name {
 			if rem == "" {
 				return e, nil
 			}
@@ -339,8 +528,8 @@ func (d *Dir) Len() int {
 }
 
 func (d *Dir) Less(i, j int) bool {
-	a := d.Entries[i].name()
-	b := d.Entries[j].name()
+	a := d.Entries[i].Name()
+	b := d.Entries[j].Name()
 	return a < b
 }
 
@@ -348,8 +537,8 @@ func (d *Dir) Swap(i, j int) {
 	d.Entries[i], d.Entries[j] = d.Entries[j], d.Entries[i]
 }
 
-func (d *Dir) name() string {
-	return d.Name
+func (d *Dir) Name() string {
+	return d.name
 }
 
 func (d *Dir) isDir() bool {
@@ -360,19 +549,20 @@ func (f *File) isDir() bool {
 	return false
 }
 
+// BakeDeepEntries rebuilds the DeepEntries of d and the directories below it.
 func (d *Dir) BakeDeepEntries() {
 	d.bakeDeepEntries()
 }
 
 func (d *Dir) bakeDeepEntries() []string {
-	var deep = d.DeepEntries[:0]
+	var deep []string
 
 	for _, e := range d.Entries {
 		// add e
-		deep = append(deep, e.name())
+		deep = append(deep, e.Name())
 
 		for _, c := range e.bakeDeepEntries() {
-			deep = append(deep, path.Join(e.name(), c))
+			deep = append(deep, path.Join(e.Name(), c))
 		}
 	}
 
@@ -382,29 +572,42 @@ func (d *Dir) bakeDeepEntries() []string {
 	return deep
 }
 
-func (f *File) name() string {
-	return f.Name
+func (f *File) Name() string {
+	return f.name
 }
 
-func (f *File) bakeDeepEntries() []string {
-	return nil
+// clone returns a copy of d named name. The entries of d are shared with the
+// copy.
+func (d *Dir) clone(name string) Entry {
+	dst := &Dir{}
+	*dst = *d
+	dst.Entries = nil
+	dst.Entries = append(dst.Entries, d.Entries...)
+	dst.name = name
+	return dst
 }
 
-func (d *Dir) ApplyIgnore(ignoreFileName string) error {
-	err := d.applyIgnore(ignoreFileName)
-	if err != nil {
-		return err
-	}
+func (f *File) clone(name string) Entry {
+	dst := &File{}
+	*dst = *f
+	dst.name = name
+	return dst
+}
 
-	d.bakeDeepEntries()
+func (f *File) bakeDeepEntries() []string {
 	return nil
 }
 
-func (f *File) applyIgnore(ignoreFileName string) error {
-	return nil
+// ApplyIgnore removes the entries matched by the ignore files named
+// ignoreFileName from d. The ignore files of the directories below d are only
+// read when the directories above them have one.
+func (d *Dir) ApplyIgnore(ignoreFileName string) error {
+	return d.applyIgnore(d, ".", ignoreFileName)
 }
 
-func (d *Dir) applyIgnore(ignoreFileName string) error {
+// applyIgnore removes the entries matched by the ignore files of d and the
+// directories below it from root. prefix is the path of d in root.
+func (d *Dir) applyIgnore(root *Dir, prefix, ignoreFileName string) error {
 	data, err := d.ReadFile(ignoreFileName)
 	if os.IsNotExist(err) {
 		return nil
@@ -413,27 +616,39 @@ func (d *Dir) applyIgnore(ignoreFileName string) error {
 		return err
 	}
 
-	lines := strings.Split(string(data), "\n")
-	ignoreRules, err := ignore.CompileIgnoreLines(lines...)
+	m, err := compileIgnore(strings.Split(string(data), "\n"))
 	if err != nil {
 		return err
 	}
 
 	for _, n := range d.DeepEntries {
-		if ignoreRules.MatchesPath(n) {
-			err := d.Remove(n)
+		e, err := d.GetEntry(n)
+		if os.IsNotExist(err) {
+			// Removed with its directory.
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if m.Match(n, e.isDir()) {
+			err := root.Remove(path.Join(prefix, n))
 			if os.IsNotExist(err) {
 				err = nil
 			}
 			if err != nil {
 				return err
 			}
-			continue
 		}
 	}
 
 	for _, e := range d.Entries {
-		err := e.applyIgnore(ignoreFileName)
+		dir, ok := e.(*Dir)
+		if !ok {
+			continue
+		}
+
+		err := dir.applyIgnore(root, path.Join(prefix, dir.name), ignoreFileName)
 		if err != nil {
 			return err
 		}
@@ -478,12 +693,104 @@ func (f *File) chmod(mask, mode uint32, recursive bool) {
 	f.Perm = os.FileMode((mask & mode) | (uint32(f.Perm) & (0xFFFFFFFF ^ mask)))
 }
 
-func (d *Dir) mode() os.FileMode  { return d.Perm }
-func (f *File) mode() os.FileMode { return f.Perm }
+// touch sets the modification time. Touched entries keep their time
+// regardless of the mtime mode of the build.
+func (d *Dir) touch(t time.Time) {
+	d.ModTime = t
+	d.Touched = true
+}
 
-func (d *Dir) writeEntriesToTar(path string, w *tar.Writer) error {
+func (f *File) touch(t time.Time) {
+	f.ModTime = t
+	f.Touched = true
+}
+
+// setXattr sets an extended attribute. The map is copied as it may be shared
+// with the entry this one was copied from.
+func (d *Dir) setXattr(name, value string) {
+	d.Xattrs = withXattr(d.Xattrs, name, value)
+}
+
+func (f *File) setXattr(name, value string) {
+	f.Xattrs = withXattr(f.Xattrs, name, value)
+}
+
+func withXattr(xattrs map[string]string, name, value string) map[string]string {
+	m := make(map[string]string, len(xattrs)+1)
+	for k, v := range xattrs {
+		m[k] = v
+	}
+	m[name] = value
+	return m
+}
+
+func (d *Dir) Mode() os.FileMode  { return d.Perm | os.ModeDir }
+func (f *File) Mode() os.FileMode { return f.Perm }
+
+func (d *Dir) Type() os.FileMode  { return os.ModeDir }
+func (f *File) Type() os.FileMode { return 0 }
+
+func (d *Dir) Owner() (user, group string)  { return d.User, d.Group }
+func (f *File) Owner() (user, group string) { return f.User, f.Group }
+
+func (d *Dir) Open() (io.ReadCloser, error) {
+	return nil, errNotRegular(d.name)
+}
+
+// Open returns the contents of f, which are read from the context or from
+// Data.
+func (f *File) Open() (io.ReadCloser, error) {
+	switch {
+	case f.OriginalName == "":
+		return ioutil.NopCloser(bytes.NewReader(f.Data)), nil
+	case f.fsys != nil:
+		return f.fsys.Open(f.OriginalName)
+	default:
+		return os.Open(f.OriginalName)
+	}
+}
+
+func errNotRegular(name string) error {
+	return &os.PathError{Op: "open", Path: name, Err: errors.New("not a regular file")}
+}
+
+// Walk calls fn for every entry below d, in the order they are written to the
+// archive. The paths are relative to d. When fn returns filepath.SkipDir for
+// a directory its entries are skipped; for another entry the rest of its
+// directory is skipped.
+func (d *Dir) Walk(fn func(path string, e Entry) error) error {
+	return d.walk("", fn)
+}
+
+// walk calls fn for every entry below d. path is the path of d.
+func (d *Dir) walk(path string, fn func(path string, e Entry) error) error {
+	for _, e := range d.Entries {
+		p := filepath.Join(path, e.Name())
+
+		err := fn(p, e)
+		if err == filepath.SkipDir {
+			if e.isDir() {
+				continue
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if dir, ok := e.(*Dir); ok {
+			err := dir.walk(p, fn)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Dir) writeEntriesToTar(path string, w *tarWriter) error {
 	for _, e := range d.Entries {
-		err := e.writeToTar(filepath.Join(path, e.name()), w)
+		err := e.writeToTar(filepath.Join(path, e.Name()), w)
 		if err != nil {
 			return err
 		}
@@ -493,20 +800,32 @@ func (d *Dir) writeEntriesToTar(path string, w *tar.Writer) error {
 
 var ftime = time.Date(1988, time.February, 1, 0, 0, 0, 0, time.UTC)
 
-func (d *Dir) writeToTar(path string, w *tar.Writer) error {
-	h := tar.Header{
+func (d *Dir) tarHeader(path string, w *tarWriter) (*tar.Header, error) {
+	mtime := w.entryModTime(d.ModTime, d.Touched)
+
+	return &tar.Header{
 		Typeflag:   tar.TypeDir,
 		Mode:       int64(d.Perm | c_ISDIR),
 		Name:       path + "/",
 		Uname:      d.User,
 		Gname:      d.Group,
+		Uid:        ownerID(d.User),
+		Gid:        ownerID(d.Group),
 		Size:       0,
-		AccessTime: ftime,
-		ChangeTime: ftime,
-		ModTime:    ftime,
+		AccessTime: mtime,
+		ChangeTime: mtime,
+		ModTime:    mtime,
+		PAXRecords: xattrRecords(d.Xattrs),
+	}, nil
+}
+
+func (d *Dir) writeToTar(path string, w *tarWriter) error {
+	h, err := d.tarHeader(path, w)
+	if err != nil {
+		return err
 	}
 
-	err := w.WriteHeader(&h)
+	err = w.writeHeader(h)
 	if err != nil {
 		return err
 	}
@@ -514,25 +833,75 @@ func (d *Dir) writeToTar(path string, w *tar.Writer) error {
 	return d.writeEntriesToTar(path, w)
 }
 
-func (f *File) writeToTar(path string, w *tar.Writer) error {
-	data, err := ioutil.ReadFile(f.OriginalName)
+// readData returns the contents of f.
+func (f *File) readData() ([]byte, error) {
+	switch {
+	case f.OriginalName == "":
+		return f.Data, nil
+	case f.fsys != nil:
+		return fs.ReadFile(f.fsys, f.OriginalName)
+	default:
+		return ioutil.ReadFile(f.OriginalName)
+	}
+}
+
+func (f *File) size() (int64, error) {
+	if f.OriginalName == "" {
+		return int64(len(f.Data)), nil
+	}
+
+	var (
+		fi  os.FileInfo
+		err error
+	)
+	if f.fsys != nil {
+		fi, err = fs.Stat(f.fsys, f.OriginalName)
+	} else {
+		fi, err = os.Stat(f.OriginalName)
+	}
 	if err != nil {
-		return err
+		return 0, err
 	}
+	return fi.Size(), nil
+}
+
+func (f *File) tarHeader(path string, w *tarWriter) (*tar.Header, error) {
+	size, err := f.size()
+	if err != nil {
+		return nil, err
+	}
+
+	mtime := w.entryModTime(f.ModTime, f.Touched)
 
-	h := tar.Header{
+	return &tar.Header{
 		Typeflag:   tar.TypeReg,
 		Mode:       int64(f.Perm | c_ISREG),
 		Name:       path,
 		Uname:      f.User,
 		Gname:      f.Group,
-		Size:       int64(len(data)),
-		AccessTime: ftime,
-		ChangeTime: ftime,
-		ModTime:    ftime,
+		Uid:        ownerID(f.User),
+		Gid:        ownerID(f.Group),
+		Size:       size,
+		AccessTime: mtime,
+		ChangeTime: mtime,
+		ModTime:    mtime,
+		PAXRecords: xattrRecords(f.Xattrs),
+	}, nil
+}
+
+func (f *File) writeToTar(path string, w *tarWriter) error {
+	data, err := f.readData()
+	if err != nil {
+		return err
+	}
+
+	h, err := f.tarHeader(path, w)
+	if err != nil {
+		return err
 	}
+	h.Size = int64(len(data))
 
-	err = w.WriteHeader(&h)
+	err = w.writeHeader(h)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,203 @@
+package tarbuild
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDeepEntries(t *testing.T) {
+	tarfile := `
+COPY a-dir data
+COPY --parents a-dir/a.txt copies
+MKDIR data/sub/dir empty
+TESTSYMLINK a.txt data/sub/link
+MKNOD data/sub/null c 1 3
+TRANSFORM s,^empty,renamed,
+COPY a-dir/b.txt data/sub/dir/a.txt
+`
+
+	r, err := build(context.Background(), "testdata", writeTarfile(t, tarfile), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every directory must have the same deep entries as a walk finds.
+	var dirs []*Dir
+	r.tree.Walk(func(path string, e Entry) error {
+		if dir, ok := e.(*Dir); ok {
+			dirs = append(dirs, dir)
+		}
+		return nil
+	})
+
+	for _, dir := range append(dirs, r.tree) {
+		var expected []string
+		dir.Walk(func(path string, e Entry) error {
+			expected = append(expected, path)
+			return nil
+		})
+		sort.Strings(expected)
+
+		if !reflect.DeepEqual(dir.DeepEntries, expected) {
+			t.Errorf("%s: expected %q but got %q", dir.Name(), expected, dir.DeepEntries)
+		}
+	}
+
+	if _, err := r.tree.GetEntry("copies/a-dir/a.txt"); err != nil {
+		t.Error(err)
+	}
+	if _, err := r.tree.GetEntry("renamed"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEntry(t *testing.T) {
+	d := NewDir()
+
+	f, err := d.AddFile("dir/file.txt", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Data = []byte("hello")
+	f.chown("app", "staff", false)
+
+	_, err = d.AddSymlink("dir/link", "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.AddNode("dev/null", CharDevice, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []string
+	err = d.Walk(func(path string, e Entry) error {
+		user, group := e.Owner()
+		entries = append(entries, path+" "+e.Mode().String()+" "+user+":"+group)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"dev drwxr-xr-x root:root",
+		"dev/null Dcrw-r--r-- root:root",
+		"dir drwxr-xr-x root:root",
+		"dir/file.txt -rw-r--r-- app:staff",
+		"dir/link Lrwxrwxrwx root:root",
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Errorf("expected %q but got %q", expected, entries)
+	}
+
+	e, err := d.GetEntry("dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Name() != "file.txt" || e.Type() != 0 {
+		t.Errorf("unexpected name %q or type %v", e.Name(), e.Type())
+	}
+
+	rc, err := e.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(data) != "hello" {
+		t.Errorf("expected hello but got %q (%v)", data, err)
+	}
+
+	if _, err := d.Open(); err == nil {
+		t.Error("expected an error when opening a directory")
+	}
+}
+
+func TestDir_ReadFile(t *testing.T) {
+	d := NewDir()
+
+	f, err := d.AddFile("dir/file.txt", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Data = []byte("hello")
+
+	_, err = d.AddSymlink("dir/link", "file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"dir/file.txt", "/dir/file.txt", "./dir/file.txt", "dir/link"} {
+		data, err := d.ReadFile(name)
+		if err != nil || string(data) != "hello" {
+			t.Errorf("%s: expected hello but got %q (%v)", name, data, err)
+		}
+	}
+
+	// The contents are a copy.
+	data, err := d.ReadFile("dir/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[0] = 'j'
+	if string(f.Data) != "hello" {
+		t.Errorf("expected the file to be unchanged but got %q", f.Data)
+	}
+
+	if _, err := d.ReadFile("dir"); err == nil {
+		t.Error("expected an error when reading a directory")
+	}
+}
+
+func TestWalk_skipDir(t *testing.T) {
+	d := NewDir()
+	d.AddFile("a/1", "")
+	d.AddFile("a/2", "")
+	d.AddFile("b/1", "")
+	d.AddFile("b/2", "")
+	d.AddFile("c", "")
+
+	var paths []string
+	err := d.Walk(func(path string, e Entry) error {
+		paths = append(paths, path)
+		if path == "a" || path == "b/1" {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"a", "b", "b/1", "c"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("expected %q but got %q", expected, paths)
+	}
+}
+
+func TestApplyIgnore(t *testing.T) {
+	src, err := NewDirFromOS("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = src.ApplyIgnore(".tarignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range src.DeepEntries {
+		if n == "ignored.txt" {
+			t.Error("ignored.txt is still in the deep entries")
+		}
+	}
+	if _, err := src.GetEntry("ignored.txt"); !os.IsNotExist(err) {
+		t.Errorf("expected ignored.txt to be removed but got %v", err)
+	}
+}
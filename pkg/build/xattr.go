@@ -0,0 +1,48 @@
+package tarbuild
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// paxXattrPrefix is the prefix of the PAX records which hold extended
+// attributes, as used by GNU tar, bsdtar and star.
+const paxXattrPrefix = "SCHILY.xattr."
+
+func xattrRecords(xattrs map[string]string) map[string]string {
+	if len(xattrs) == 0 {
+		return nil
+	}
+
+	records := make(map[string]string, len(xattrs))
+	for k, v := range xattrs {
+		records[paxXattrPrefix+k] = v
+	}
+	return records
+}
+
+// parseXattrValue decodes a value the way setfattr(1) does: values prefixed
+// with 0x are hex encoded, values prefixed with 0s are base64 encoded and
+// other values are used as is.
+func parseXattrValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "0x") || strings.HasPrefix(value, "0X"):
+		data, err := hex.DecodeString(value[2:])
+		if err != nil {
+			return "", fmt.Errorf("invalid hex value %q", value)
+		}
+		return string(data), nil
+
+	case strings.HasPrefix(value, "0s") || strings.HasPrefix(value, "0S"):
+		data, err := base64.StdEncoding.DecodeString(value[2:])
+		if err != nil {
+			return "", fmt.Errorf("invalid base64 value %q", value)
+		}
+		return string(data), nil
+
+	default:
+		return value, nil
+	}
+}
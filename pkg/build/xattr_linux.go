@@ -0,0 +1,68 @@
+//go:build linux
+// +build linux
+
+package tarbuild
+
+import (
+	"bytes"
+	"syscall"
+)
+
+func readXattrs(name string) (map[string]string, error) {
+	buf, err := readXattr(func(dst []byte) (int, error) {
+		return syscall.Listxattr(name, dst)
+	})
+	if err == syscall.ENOTSUP || len(buf) == 0 {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	xattrs := map[string]string{}
+
+	for _, key := range bytes.Split(buf, []byte{0}) {
+		if len(key) == 0 {
+			continue
+		}
+
+		value, err := readXattr(func(dst []byte) (int, error) {
+			return syscall.Getxattr(name, string(key), dst)
+		})
+		if err == syscall.ENODATA {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		xattrs[string(key)] = string(value)
+	}
+
+	return xattrs, nil
+}
+
+// readXattr calls read with a nil buffer to get the size of the value, then
+// with a buffer of that size. It retries when the value grew in between.
+func readXattr(read func(dst []byte) (int, error)) ([]byte, error) {
+	for {
+		size, err := read(nil)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return nil, nil
+		}
+
+		buf := make([]byte, size)
+		size, err = read(buf)
+		if err == syscall.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return buf[:size], nil
+	}
+}
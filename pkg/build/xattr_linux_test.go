@@ -0,0 +1,39 @@
+//go:build linux
+// +build linux
+
+package tarbuild
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestReadXattrs(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "a.txt")
+	err := os.WriteFile(name, []byte("a"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = syscall.Setxattr(name, "user.empty", nil, 0)
+	if err != nil {
+		t.Skipf("extended attributes aren't supported: %v", err)
+	}
+	err = syscall.Setxattr(name, "user.comment", []byte("hello"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	xattrs, err := readXattrs(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"user.empty": "", "user.comment": "hello"}
+	if !reflect.DeepEqual(xattrs, expected) {
+		t.Errorf("expected %q but got %q", expected, xattrs)
+	}
+}
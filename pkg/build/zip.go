@@ -0,0 +1,200 @@
+package tarbuild
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+func init() {
+	registerOp("ORDER", validateORDER, nil)
+}
+
+// ZipMethod is the compression method of the files in a zip archive.
+type ZipMethod string
+
+const (
+	// ZipDeflate compresses files with deflate. It is the default.
+	ZipDeflate ZipMethod = "deflate"
+
+	// ZipStore stores files without compression.
+	ZipStore ZipMethod = "store"
+)
+
+// ParseZipMethod parses the name of a zip compression method.
+func ParseZipMethod(name string) (ZipMethod, error) {
+	switch m := ZipMethod(name); m {
+	case "":
+		return ZipDeflate, nil
+	case ZipDeflate, ZipStore:
+		return m, nil
+	}
+	return ZipDeflate, fmt.Errorf("invalid zip method %q: expected deflate or store", name)
+}
+
+// WithZipMethod sets the compression method of zip archives.
+func WithZipMethod(m ZipMethod) Option {
+	return func(c *buildConfig) {
+		c.zipMethod = m
+	}
+}
+
+func (m ZipMethod) method() uint16 {
+	if m == ZipStore {
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+// Zip entries are made on Unix by version 2.0 of the spec, so the external
+// attributes hold the Unix mode in the high 16 bits like Info-ZIP does.
+const (
+	zipCreatorUnix   = 3<<8 | 20
+	zipAttrDirectory = 0x10
+)
+
+// zipEntry is an entry of the tree and its path.
+type zipEntry struct {
+	path  string
+	entry Entry
+}
+
+// writeZip writes the tree as a zip archive. Directories get a trailing
+// slash and symbolic links store their target as contents. Owners can't be
+// stored and are dropped. The entries matched by ORDER come first.
+func (r *result) writeZip(dst io.Writer) error {
+	var entries []zipEntry
+
+	err := r.tree.walk("", func(path string, e Entry) error {
+		entries = append(entries, zipEntry{path, e})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	entries, err = orderEntries(entries, r.order)
+	if err != nil {
+		return err
+	}
+
+	var (
+		w       = r.newTarWriter(ioutil.Discard)
+		zw      = zip.NewWriter(dst)
+		invalid []string
+	)
+
+	for _, ze := range entries {
+		h, err := ze.entry.tarHeader(ze.path, w)
+		if err != nil {
+			return err
+		}
+
+		switch h.Typeflag {
+		case tar.TypeReg, tar.TypeDir, tar.TypeSymlink:
+		default:
+			invalid = append(invalid, fmt.Sprintf("%q", ze.path))
+			continue
+		}
+		if len(h.PAXRecords) > 0 {
+			invalid = append(invalid, fmt.Sprintf("%q", ze.path))
+			continue
+		}
+		if len(invalid) > 0 {
+			continue
+		}
+
+		fh := &zip.FileHeader{
+			Name:           h.Name,
+			Method:         zip.Store,
+			Modified:       h.ModTime.UTC(),
+			CreatorVersion: zipCreatorUnix,
+			ExternalAttrs:  uint32(h.Mode) << 16,
+		}
+
+		var data []byte
+		switch h.Typeflag {
+		case tar.TypeDir:
+			fh.ExternalAttrs |= zipAttrDirectory
+		case tar.TypeSymlink:
+			data = []byte(h.Linkname)
+		case tar.TypeReg:
+			fh.Method = r.cfg.zipMethod.method()
+			data, err = ze.entry.(*File).readData()
+			if err != nil {
+				return err
+			}
+		}
+
+		var size int64
+		if h.Typeflag == tar.TypeReg {
+			size = int64(len(data))
+		}
+		err = r.writeEntry(ze.path, size)
+		if err != nil {
+			return err
+		}
+
+		fw, err := zw.CreateHeader(fh)
+		if err != nil {
+			return err
+		}
+
+		_, err = fw.Write(data)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(invalid) > 0 {
+		return fmt.Errorf("the zip format can't represent %s (only files, directories and symlinks without xattrs)", strings.Join(invalid, ", "))
+	}
+
+	return zw.Close()
+}
+
+func validateORDER(op *Op) error {
+	if len(op.Args) == 0 {
+		return fmt.Errorf("invalid command: %q requires arguments", op.Name)
+	}
+	_, err := compileMatcher(op.Args)
+	return err
+}
+
+// orderEntries moves the entries matched by the patterns of ORDER to the
+// front, in the order of the patterns. The other entries keep their order.
+func orderEntries(entries []zipEntry, order []string) ([]zipEntry, error) {
+	if len(order) == 0 {
+		return entries, nil
+	}
+
+	var (
+		ordered = make([]zipEntry, 0, len(entries))
+		taken   = make([]bool, len(entries))
+	)
+
+	for _, pattern := range order {
+		m, err := compileMatcher([]string{pattern})
+		if err != nil {
+			return nil, err
+		}
+
+		for i, ze := range entries {
+			if !taken[i] && m.Match(ze.path, ze.entry.isDir()) {
+				ordered = append(ordered, ze)
+				taken[i] = true
+			}
+		}
+	}
+
+	for i, ze := range entries {
+		if !taken[i] {
+			ordered = append(ordered, ze)
+		}
+	}
+
+	return ordered, nil
+}
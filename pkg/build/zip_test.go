@@ -0,0 +1,128 @@
+package tarbuild
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestBuild_zip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the context has a symbolic link")
+	}
+
+	// The symbolic link comes from the context.
+	wd, err := ioutil.TempDir("", "tarbuild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(wd)
+
+	err = os.Mkdir(filepath.Join(wd, "data"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		err := ioutil.WriteFile(filepath.Join(wd, "data", name), []byte(name), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = os.Symlink("b.txt", filepath.Join(wd, "data", "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tarfile := `
+COPY data data
+CHMOD 0755 data/b.txt
+ORDER data/b.txt
+`
+
+	for _, method := range []ZipMethod{ZipDeflate, ZipStore} {
+		var buf bytes.Buffer
+		err := Build(&buf, wd, writeTarfile(t, tarfile), WithOutput(OutputZIP), WithZipMethod(method), WithContextSymlinks())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var names []string
+		files := map[string]*zip.File{}
+		for _, f := range r.File {
+			names = append(names, f.Name)
+			files[f.Name] = f
+		}
+
+		expected := []string{"data/b.txt", "data/", "data/a.txt", "data/link"}
+		if !reflect.DeepEqual(names, expected) {
+			t.Fatalf("expected %q but got %q", expected, names)
+		}
+
+		if m := files["data/b.txt"].Mode(); m != 0755 {
+			t.Errorf("expected data/b.txt to be 0755 but got %v", m)
+		}
+		if files["data/b.txt"].Method != method.method() {
+			t.Errorf("expected method %d but got %d", method.method(), files["data/b.txt"].Method)
+		}
+		if !files["data/"].Mode().IsDir() {
+			t.Errorf("expected data/ to be a directory")
+		}
+		if !files["data/a.txt"].Modified.Equal(ftime) {
+			t.Errorf("expected a fixed time but got %v", files["data/a.txt"].Modified)
+		}
+
+		link := files["data/link"]
+		if link.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("expected data/link to be a symlink but got %v", link.Mode())
+		}
+		rc, err := link.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		target, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		if string(target) != "b.txt" {
+			t.Errorf("unexpected target %q", target)
+		}
+	}
+}
+
+func TestBuild_zipNodes(t *testing.T) {
+	err := Build(ioutil.Discard, "testdata", writeTarfile(t, "MKFIFO fifo\n"), WithOutput(OutputZIP))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestBuild_orderOutputs(t *testing.T) {
+	conf := writeTarfile(t, "COPY a-dir data\nORDER data/b.txt\n")
+
+	for _, output := range []Output{OutputTar, OutputCPIO} {
+		err := Build(ioutil.Discard, "testdata", conf, WithOutput(output))
+		if err != errOrder {
+			t.Errorf("%q: expected %v but got %v", output, errOrder, err)
+		}
+	}
+
+	_, err := BuildLayers("testdata", conf)
+	if err != errOrder {
+		t.Errorf("layers: expected %v but got %v", errOrder, err)
+	}
+}
+
+func TestBuild_zipCompression(t *testing.T) {
+	err := Build(ioutil.Discard, "testdata", writeTarfile(t, "COPY a-dir data\n"), WithOutput(OutputZIP), WithCompression(CompressionGzip))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}